@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// максимальная длительность кружка
+const maxCircleDuration = 60 * time.Second
+
+func init() {
+	registerProcessor("circle", newCircleProcessor)
+}
+
+// circleProcessor - кружки (circle_jobs): обрезка видео в квадрат 512x512 через ffmpeg
+type circleProcessor struct{}
+
+func newCircleProcessor() (Processor, error) {
+	return &circleProcessor{}, nil
+}
+
+func (p *circleProcessor) Collection() string {
+	return "circle_jobs"
+}
+
+func (p *circleProcessor) Inputs(task *Task) (map[string]string, error) {
+	if task.InputMedia == "" {
+		return nil, fmt.Errorf("задача с ID %s не содержит ссылки на input_media", task.ID)
+	}
+	return map[string]string{"input_media": task.InputMedia}, nil
+}
+
+func (p *circleProcessor) Process(ctx context.Context, inputs map[string]string, outputPath string, progress func(percent int)) error {
+	return processVideo(ctx, inputs["input_media"], outputPath, progress)
+}
+
+func (p *circleProcessor) Delivery() (string, string) {
+	return "sendVideoNote", "video_note"
+}
+
+func (p *circleProcessor) Counter() string {
+	return "circle_count"
+}
+
+// Обработка файла
+func processVideo(ctx context.Context, inputPath, outputPath string, progress func(percent int)) error {
+	// без длительности обработка идет без прогресса
+	total, err := videoDuration(ctx, inputPath)
+	if err != nil {
+		log.Printf("Не удалось определить длительность %s, прогресс недоступен: %v", inputPath, err)
+	}
+	total = min(total, maxCircleDuration)
+
+	return runFFmpeg(ctx, []string{
+		"-i", inputPath,
+		"-vf", "crop=min(iw\\,ih):min(iw\\,ih):(iw-min(iw\\,ih))/2:(ih-min(iw\\,ih))/2,scale=512:512",
+		"-r", "30",
+		"-t", fmt.Sprint(int(maxCircleDuration.Seconds())),
+		"-c:v", "libx264",
+		// GIF и анимации приходят в RGB/палитре, Telegram воспроизводит кружки только в yuv420p
+		"-pix_fmt", "yuv420p",
+		"-preset", "fast",
+		"-crf", "23",
+		outputPath,
+	}, total, progress)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"pbclient"
+)
+
+// fakeJobs - замена pocketbase для захвата задач: список записей и маршрут /claim
+// с той же семантикой, что у pocketbase/pb_hooks/jobs.pb.js
+type fakeJobs struct {
+	mu        sync.Mutex
+	tasks     map[string]*Task
+	conflicts int
+	// если задан, сразу после захвата задачу забирает этот воркер:
+	// аренда истекла и задачу захватили снова до того, как первый воркер перечитал запись
+	takeover string
+}
+
+func newFakeJobs(count int) *fakeJobs {
+	f := &fakeJobs{tasks: make(map[string]*Task)}
+	created := time.Now().Add(-time.Hour)
+	for i := 0; i < count; i++ {
+		task := &Task{}
+		task.ID = fmt.Sprintf("job%03d", i)
+		task.Created = pbclient.NewDateTime(created.Add(time.Duration(i) * time.Second))
+		task.Status = "queued"
+		f.tasks[task.ID] = task
+	}
+	return f
+}
+
+func (f *fakeJobs) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// список задач в статусе queued по возрастанию created; фильтр не разбирается
+	mux.HandleFunc("GET /api/collections/{collection}/records", func(w http.ResponseWriter, r *http.Request) {
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("perPage"))
+
+		f.mu.Lock()
+		var items []Task
+		for _, task := range f.tasks {
+			if task.Status == "queued" {
+				items = append(items, *task)
+			}
+		}
+		f.mu.Unlock()
+
+		sort.Slice(items, func(i, j int) bool { return items[i].Created.Before(items[j].Created.Time) })
+		total := len(items)
+		if perPage > 0 && len(items) > perPage {
+			items = items[:perPage]
+		}
+		writeJSON(w, http.StatusOK, pbclient.ListResult[Task]{Page: 1, PerPage: perPage, TotalItems: total, TotalPages: 1, Items: items})
+	})
+
+	mux.HandleFunc("GET /api/collections/{collection}/records/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		task, ok := f.tasks[r.PathValue("id")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"code": 404, "message": "not found", "data": map[string]interface{}{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, task)
+	})
+
+	// захват: проверка статуса и запись worker_id атомарны, как в транзакции pocketbase
+	mux.HandleFunc("POST /api/jobs/{collection}/{id}/claim", func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			WorkerID string `json:"worker_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.WorkerID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"code": 400, "message": "worker_id is required", "data": map[string]interface{}{}})
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		task, ok := f.tasks[r.PathValue("id")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"code": 404, "message": "not found", "data": map[string]interface{}{}})
+			return
+		}
+		if task.Status != "queued" {
+			f.conflicts++
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"code": 409, "message": "job is not queued", "data": map[string]interface{}{}})
+			return
+		}
+
+		task.Status = "processing"
+		task.WorkerID = data.WorkerID
+		task.ClaimedAt = pbclient.NewDateTime(time.Now())
+		task.Attempts++
+		writeJSON(w, http.StatusOK, task)
+		if f.takeover != "" {
+			task.WorkerID = f.takeover
+		}
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Воркеры с разными worker_id захватывают очередь одновременно: у каждой задачи один победитель,
+// и в задаче записан именно его worker_id
+func TestClaimQueuedJobConcurrent(t *testing.T) {
+	const (
+		jobCount = 100
+		claimers = 16
+	)
+
+	fake := newFakeJobs(jobCount)
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	pb = pbclient.New(server.URL, "admin@example.com", "password")
+
+	var (
+		mu      sync.Mutex
+		winners = make(map[string][]string)
+		wg      sync.WaitGroup
+		errs    = make(chan error, claimers)
+	)
+	for i := 0; i < claimers; i++ {
+		store := &pocketBaseStore{workerID: fmt.Sprintf("worker-%02d", i)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := store.ClaimQueued(pbclient.CircleJobsCollection)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if task == nil {
+					return
+				}
+				if task.WorkerID != store.workerID {
+					errs <- fmt.Errorf("%s получил задачу %s с worker_id %s", store.workerID, task.ID, task.WorkerID)
+					return
+				}
+
+				mu.Lock()
+				winners[task.ID] = append(winners[task.ID], store.workerID)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("захват: %v", err)
+	}
+	if len(winners) != jobCount {
+		t.Fatalf("захвачено %d задач из %d", len(winners), jobCount)
+	}
+
+	workers := make(map[string]bool)
+	for id, task := range fake.tasks {
+		if len(winners[id]) != 1 {
+			t.Errorf("задачу %s захватили %v", id, winners[id])
+			continue
+		}
+		if task.Status != "processing" || task.WorkerID != winners[id][0] || task.Attempts != 1 {
+			t.Errorf("задача %s: status=%s worker_id=%s attempts=%d, захватил %s", id, task.Status, task.WorkerID, task.Attempts, winners[id][0])
+		}
+		workers[task.WorkerID] = true
+	}
+	t.Logf("конфликтов захвата: %d, задачи получили %d воркеров", fake.conflicts, len(workers))
+}
+
+func TestClaimTaskConflict(t *testing.T) {
+	fake := newFakeJobs(2)
+	fake.tasks["job000"].Status = "processing"
+	fake.tasks["job000"].WorkerID = "other-worker"
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	pb = pbclient.New(server.URL, "admin@example.com", "password")
+
+	task, err := claimTask(pbclient.CircleJobsCollection, "job000", "test-worker")
+	if err != nil || task != nil {
+		t.Fatalf("захват чужой задачи: task=%v err=%v", task, err)
+	}
+
+	task, err = claimTask(pbclient.CircleJobsCollection, "missing", "test-worker")
+	if err != nil || task != nil {
+		t.Fatalf("захват удаленной задачи: task=%v err=%v", task, err)
+	}
+
+	// захват прошел, но в записи уже другой worker_id: задача не наша
+	fake.takeover = "other-worker"
+	task, err = claimTask(pbclient.CircleJobsCollection, "job001", "test-worker")
+	if err != nil || task != nil {
+		t.Fatalf("захват задачи, перешедшей к другому воркеру: task=%v err=%v", task, err)
+	}
+	if fake.tasks["job001"].WorkerID != "other-worker" {
+		t.Errorf("worker_id задачи %s", fake.tasks["job001"].WorkerID)
+	}
+}
@@ -1,232 +1,255 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
-)
-
-// getting JWT for pocketbase
-func authenticatePocketBase() error {
-	authData := map[string]string{
-		"identity": email,
-		"password": password,
-	}
+	"time"
 
-	authDataJson, _ := json.Marshal(authData)
-
-	authURL := fmt.Sprintf("%s/api/admins/auth-with-password", pocketBaseUrl)
+	"pbclient"
+)
 
-	resp, err := http.Post(authURL, "application/json", bytes.NewBuffer(authDataJson))
+// Увеличение счетчика пользователя (circle_count, face_replace_count) на 1.
+// Модификатор "поле+" pocketbase прибавляет значение в базе, поэтому одновременные задачи не теряют приращения.
+func incrementUserCounter(userID, field string) error {
+	err := pb.Update(pbclient.UsersCollection, userID, map[string]interface{}{field + "+": 1}, nil)
 	if err != nil {
-		return fmt.Errorf("не удалось отправить запрос на авторизацию: %v", err)
+		return fmt.Errorf("ошибка обновления %s для пользователя %s: %w", field, userID, err)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("авторизация не удалась, код %d, ответ: %s", resp.StatusCode, string(body))
+// Загрузка обработанного файла в output_media
+func uploadOutputMedia(collection, taskID, filePath string) error {
+	fields := map[string]string{
+		"status":   "sending",
+		"progress": "100",
 	}
+	files := []pbclient.File{{Field: "output_media", Path: filePath}}
 
-	// getting jwt
-	body, _ := io.ReadAll(resp.Body)
-	var authResponse map[string]interface{}
-	if err := json.Unmarshal(body, &authResponse); err != nil {
-		return fmt.Errorf("ошибка разбора ответа: %v, ответ: %s", err, string(body))
+	err := pb.UpdateWithFiles(collection, taskID, fields, files, nil)
+	if cancelledUpdate(err) {
+		return errJobCancelled
 	}
-
-	token, ok := authResponse["token"].(string)
-	if !ok || token == "" {
-		return fmt.Errorf("не удалось получить токен из ответа: %s", string(body))
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки файла: %w", err)
 	}
 
-	authToken = token
-	log.Println("PocketBase: Авторизация прошла успешно. Получен токен от PocketBase.")
 	return nil
 }
 
-func getUserInfo(tgUserID int) (map[string]interface{}, error) {
-	// Поиск пользователя в PocketBase по tgid
-	searchURL := fmt.Sprintf("%s/api/collections/users/records?filter=tgid=%d", pocketBaseUrl, tgUserID)
-	resp, err := sendAuthorizedRequest("GET", searchURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка при запросе пользователя: %v", err)
+// Получение Telegram ID владельца
+func getOwnerTGID(ownerID string) (string, error) {
+	owner, err := pb.GetUser(ownerID)
+	if pbclient.IsNotFound(err) {
+		return "", fmt.Errorf("владелец %s удален из бд: %w", ownerID, err)
 	}
-
-	var searchResult map[string]interface{}
-	if err := json.Unmarshal(resp, &searchResult); err != nil {
-		return nil, fmt.Errorf("ошибка разбора ответа при получении пользователя: %v", err)
+	if err != nil {
+		return "", fmt.Errorf("ошибка получения данных о владельце: %w", err)
 	}
 
-	if items, ok := searchResult["items"].([]interface{}); ok && len(items) > 0 {
-		if user, ok := items[0].(map[string]interface{}); ok {
-			return user, nil
-		}
+	if owner.TGID == 0 {
+		return "", fmt.Errorf("telegram id владельца %s не найден", ownerID)
 	}
 
-	return nil, fmt.Errorf("пользователь с Telegram ID %d не найден", tgUserID)
+	return strconv.Itoa(owner.TGID), nil
 }
 
-// Увеличение circle_count на 1 для владельца задачи
-func incrementCircleCount(tgUserID int) error {
-	userInfo, err := getUserInfo(tgUserID)
+// Захват первой свободной задачи в статусе "queued".
+// Кандидаты берутся списком, потому что между чтением и захватом их могут забрать другие воркеры.
+func claimQueuedJob(collection, workerID string) (*Task, error) {
+	filter, err := pbclient.Filter("status={:status} && (next_attempt_at='' || next_attempt_at<={:now})", pbclient.Params{
+		"status": "queued",
+		"now":    time.Now(),
+	})
 	if err != nil {
-		return fmt.Errorf("ошибка получения информации о пользователе с Telegram ID %d: %v", tgUserID, err)
-	}
-	currentCircleCount, ok := userInfo["circle_count"].(float64) // JSON numbers в Go парсятся в float64
-	if !ok {
-		currentCircleCount = 0
+		return nil, err
 	}
 
-	newCircleCount := int(currentCircleCount) + 1
-
-	updateData := map[string]interface{}{
-		"circle_count": newCircleCount,
+	result, err := pbclient.List[Task](pb, collection, pbclient.ListQuery{
+		Filter:  filter,
+		Sort:    "created",
+		PerPage: 10,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при запросе задач: %w", err)
 	}
 
-	userID, ok := userInfo["id"].(string)
-	if !ok {
-		return fmt.Errorf("не удалось извлечь ID пользователя с Telegram ID %d", tgUserID)
+	for _, candidate := range result.Items {
+		task, err := claimTask(collection, candidate.ID, workerID)
+		if err != nil {
+			return nil, err
+		}
+		if task != nil {
+			return task, nil
+		}
 	}
 
-	updateURL := fmt.Sprintf("%s/api/collections/users/records/%s", pocketBaseUrl, userID)
+	return nil, nil // Нет задач в статусе "queued"
+}
 
-	jsonData, err := json.Marshal(updateData)
+// Захват задачи воркером workerID через /api/jobs/.../claim (pocketbase/pb_hooks).
+// Возвращает nil без ошибки, если задачу уже захватил другой воркер.
+func claimTask(collection, taskID, workerID string) (*Task, error) {
+	err := pb.JobAction(collection, taskID, "claim", map[string]interface{}{
+		"worker_id":     workerID,
+		"lease_seconds": int(leaseDuration.Seconds()),
+	}, nil)
+	if taken(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации данных для обновления: %v", err)
+		return nil, fmt.Errorf("ошибка захвата задачи %s: %w", taskID, err)
 	}
 
-	_, err = sendAuthorizedRequest("PATCH", updateURL, jsonData)
+	// Перечитываем запись: задача наша, только если в базе записан наш worker_id
+	task, err := getTask(collection, taskID)
+	if pbclient.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка обновления circle_count для пользователя %s: %v", userID, err)
+		return nil, fmt.Errorf("ошибка проверки захвата задачи %s: %w", taskID, err)
+	}
+	if task.WorkerID != workerID || task.Status != "processing" {
+		log.Printf("Задача %s захвачена воркером %s", taskID, task.WorkerID)
+		return nil, nil
 	}
 
-	// log.Printf("circle_count для пользователя с Telegram ID %d успешно обновлен. Новое значение: %d", tgUserID, newCircleCount)
-	return nil
+	return task, nil
 }
 
-// Загрузка обработанного файла в output_media
-func uploadOutputMedia(taskID, filePath string) error {
-	url := fmt.Sprintf("%s/api/collections/circle_jobs/records/%s", pocketBaseUrl, taskID)
+// аренда задачи перешла к другому воркеру или задача сменила статус
+var errLeaseLost = errors.New("аренда задачи потеряна")
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("ошибка открытия файла: %v", err)
-	}
-	defer file.Close()
+// задачу отменил пользователь (/api/jobs/.../cancel в pocketbase/pb_hooks)
+var errJobCancelled = errors.New("задача отменена пользователем")
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// pocketbase отвечает 409 на изменение отмененной задачи
+func cancelledUpdate(err error) bool {
+	return pbclient.StatusCode(err) == http.StatusConflict
+}
 
-	// Добавляем файл
-	filePart, err := writer.CreateFormFile("output_media", filepath.Base(filePath))
-	if err != nil {
-		return fmt.Errorf("ошибка добавления файла в запрос: %v", err)
-	}
-	_, err = io.Copy(filePart, file)
-	if err != nil {
-		return fmt.Errorf("ошибка копирования содержимого файла: %v", err)
-	}
+// Ответ маршрута задач о том, что задача уже не в нужном статусе или удалена
+func taken(err error) bool {
+	return pbclient.StatusCode(err) == http.StatusConflict || pbclient.IsNotFound(err)
+}
 
-	// Завершаем формирование multipart
-	writer.WriteField("status", "completed")
-	err = writer.Close()
-	if err != nil {
-		return fmt.Errorf("ошибка завершения multipart: %v", err)
+// Продление аренды задачи
+func heartbeatTask(collection, taskID, workerID string) error {
+	err := pb.JobAction(collection, taskID, "heartbeat", map[string]interface{}{
+		"worker_id":     workerID,
+		"lease_seconds": int(leaseDuration.Seconds()),
+	}, nil)
+	if pbclient.StatusCode(err) == http.StatusGone {
+		return errJobCancelled
+	}
+	if taken(err) {
+		return errLeaseLost
 	}
-
-	request, err := http.NewRequest("PATCH", url, body)
 	if err != nil {
-		return fmt.Errorf("ошибка создания запроса: %v", err)
+		return fmt.Errorf("ошибка продления аренды задачи %s: %w", taskID, err)
 	}
 
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
-	request.Header.Set("Content-Type", writer.FormDataContentType())
-	client := &http.Client{}
+	return nil
+}
 
-	resp, err := client.Do(request)
-	if err != nil {
-		return fmt.Errorf("ошибка отправки запроса: %v", err)
+// Возврат своей задачи в очередь без учета попытки
+func releaseTask(collection, taskID, workerID string) error {
+	err := pb.JobAction(collection, taskID, "release", map[string]interface{}{
+		"worker_id": workerID,
+	}, nil)
+	if taken(err) {
+		return errLeaseLost
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("ошибка загрузки файла: статус %d, ответ: %s", resp.StatusCode, string(respBody))
+	if err != nil {
+		return fmt.Errorf("ошибка возврата задачи %s: %w", taskID, err)
 	}
 
 	return nil
 }
 
-// Получение Telegram ID владельца
-func getOwnerTGID(ownerID string) (string, error) {
-	url := fmt.Sprintf("%s/api/collections/users/records/%s", pocketBaseUrl, ownerID)
-	body, err := sendAuthorizedRequest("GET", url, nil)
+// Возврат в очередь задач с истекшей арендой
+func reapExpiredTasks(collection string) (requeued, dead []string, err error) {
+	var response struct {
+		Requeued []string `json:"requeued"`
+		Dead     []string `json:"dead"`
+	}
+	err = pb.JobAction(collection, "", "reap", map[string]interface{}{
+		"max_attempts": maxAttempts,
+	}, &response)
 	if err != nil {
-		return "", fmt.Errorf("ошибка получения данных о владельце: %v", err)
+		return nil, nil, fmt.Errorf("ошибка возврата задач: %w", err)
 	}
 
-	var ownerData struct {
-		TGID int `json:"tgid"`
-	}
+	return response.Requeued, response.Dead, nil
+}
 
-	err = json.Unmarshal(body, &ownerData)
+// Получение задачи по ID
+func getTask(collection, taskID string) (*Task, error) {
+	task, err := pbclient.Get[Task](pb, collection, taskID)
 	if err != nil {
-		return "", fmt.Errorf("ошибка разбора данных о владельце: %v", err)
-	}
-
-	if ownerData.TGID == 0 {
-		return "", fmt.Errorf("telegram id владельца %s не найден", ownerID)
+		return nil, fmt.Errorf("ошибка получения задачи: %w", err)
 	}
 
-	return strconv.Itoa(ownerData.TGID), nil
+	return task, nil
 }
 
-// Получение задачи в статусе "queued"
-func fetchQueuedCircleJob(collection string) (*Task, error) {
-	filter := "status='queued'"
-	url := fmt.Sprintf("%s/api/collections/%s/records?filter=%s&perPage=1", pocketBaseUrl, collection, filter)
+// Обновление статуса задачи
+func updateTaskStatus(collection, taskID, status string) error {
+	data := map[string]interface{}{
+		"status": status,
+	}
+	if status == "completed" {
+		data["completed_at"] = pbclient.NewDateTime(time.Now())
+	}
 
-	body, err := sendAuthorizedRequest("GET", url, nil)
+	err := pb.Update(collection, taskID, data, nil)
+	if cancelledUpdate(err) {
+		return errJobCancelled
+	}
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при запросе задач: %v", err)
+		return fmt.Errorf("ошибка обновления статуса задачи: %w", err)
 	}
 
-	var response struct {
-		Items []Task `json:"items"`
-	}
+	return nil
+}
 
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка разбора JSON: %v", err)
+// Обновление прогресса обработки задачи
+func updateTaskProgress(collection, taskID string, percent int) error {
+	data := map[string]int{
+		"progress": percent,
 	}
 
-	if len(response.Items) > 0 {
-		return &response.Items[0], nil
+	err := pb.Update(collection, taskID, data, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления прогресса задачи: %w", err)
 	}
 
-	return nil, nil // Нет задач в статусе "queued"
+	return nil
 }
 
-// Обновление статуса задачи
-func updateTaskStatus(taskID, status string) error {
-	url := fmt.Sprintf("%s/api/collections/circle_jobs/records/%s", pocketBaseUrl, taskID)
-
-	data := map[string]string{
-		"status": status,
+// Запись неудачной попытки: 'failed', 'dead' или возврат в очередь до next_attempt_at
+func failTask(collection, taskID string, f failure) error {
+	data := map[string]interface{}{
+		"status":        f.status,
+		"error_stage":   f.stage,
+		"error_message": f.message,
+		"failed_at":     pbclient.NewDateTime(time.Now()),
+	}
+	if f.status == "queued" {
+		data["worker_id"] = ""
+		data["lease_expires_at"] = ""
+		data["next_attempt_at"] = pbclient.NewDateTime(f.nextAttempt)
 	}
-	jsonData, _ := json.Marshal(data)
 
-	_, err := sendAuthorizedRequest("PATCH", url, jsonData)
+	err := pb.Update(collection, taskID, data, nil)
+	if cancelledUpdate(err) {
+		return errJobCancelled
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка обновления статуса задачи: %v", err)
+		return fmt.Errorf("ошибка записи сбоя задачи: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Бэкенд замены лица: по фото лица и видео создает видео с замененным лицом
+type faceSwapper interface {
+	swapFace(ctx context.Context, facePath, mediaPath, outputPath string) error
+}
+
+// Внешняя команда замены лица из FACE_SWAP_COMMAND.
+// Аргументы {face}, {media} и {output} заменяются путями к файлам.
+type commandFaceSwapper struct {
+	args []string
+}
+
+func (s *commandFaceSwapper) swapFace(ctx context.Context, facePath, mediaPath, outputPath string) error {
+	replacer := strings.NewReplacer("{face}", facePath, "{media}", mediaPath, "{output}", outputPath)
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = replacer.Replace(arg)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ошибка команды замены лица: %v, вывод: %s", err, string(output))
+	}
+
+	if _, err := os.Stat(outputPath); err != nil {
+		return fmt.Errorf("команда замены лица не создала результат: %v", err)
+	}
+	return nil
+}
+
+// Заглушка без GPU: возвращает исходное видео без изменений.
+// Нужна для проверки конвейера face_jobs без модели.
+type stubFaceSwapper struct{}
+
+func (s *stubFaceSwapper) swapFace(ctx context.Context, facePath, mediaPath, outputPath string) error {
+	src, err := os.Open(mediaPath)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия видео: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла: %v", err)
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return fmt.Errorf("ошибка копирования видео: %v", err)
+	}
+	return nil
+}
+
+// Бэкенд из окружения: FACE_SWAP_COMMAND=stub для заглушки, иначе внешняя команда.
+// nil, если замена лиц не настроена.
+func loadFaceSwapper() faceSwapper {
+	command := strings.TrimSpace(os.Getenv("FACE_SWAP_COMMAND"))
+	switch command {
+	case ``:
+		return nil
+	case "stub":
+		return &stubFaceSwapper{}
+	default:
+		return &commandFaceSwapper{args: strings.Fields(command)}
+	}
+}
+
+func init() {
+	registerProcessor("face", newFaceProcessor)
+}
+
+// faceProcessor - замена лица (face_jobs) бэкендом faceSwapper
+type faceProcessor struct {
+	swapper faceSwapper
+}
+
+func newFaceProcessor() (Processor, error) {
+	swapper := loadFaceSwapper()
+	if swapper == nil {
+		return nil, fmt.Errorf("FACE_SWAP_COMMAND не задан")
+	}
+	return &faceProcessor{swapper: swapper}, nil
+}
+
+func (p *faceProcessor) Collection() string {
+	return "face_jobs"
+}
+
+func (p *faceProcessor) Inputs(task *Task) (map[string]string, error) {
+	if task.InputMedia == "" || task.InputFace == "" {
+		return nil, fmt.Errorf("задача с ID %s не содержит input_media или input_face", task.ID)
+	}
+	return map[string]string{
+		"input_face":  task.InputFace,
+		"input_media": task.InputMedia,
+	}, nil
+}
+
+// команда замены лица не сообщает о прогрессе
+func (p *faceProcessor) Process(ctx context.Context, inputs map[string]string, outputPath string, progress func(percent int)) error {
+	return p.swapper.swapFace(ctx, inputs["input_face"], inputs["input_media"], outputPath)
+}
+
+func (p *faceProcessor) Delivery() (string, string) {
+	return "sendVideo", "video"
+}
+
+func (p *faceProcessor) Counter() string {
+	return "face_replace_count"
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Задача face_jobs owner1 с фото лица и видео
+func testFaceTask(store *fakeStore) *Task {
+	task := &Task{}
+	task.ID = "face1"
+	task.Owner = "owner1"
+	task.InputFace = "face.jpg"
+	task.InputMedia = "media.mp4"
+	task.Status = "processing"
+	task.Attempts = 1
+
+	store.tasks[task.ID] = task
+	store.files["face.jpg"] = "face"
+	store.files["media.mp4"] = "media"
+	store.tgids["owner1"] = "1001"
+	return task
+}
+
+// face_jobs через worker с заглушкой FACE_SWAP_COMMAND=stub: результат - исходное видео
+func TestFaceJobWithStub(t *testing.T) {
+	t.Setenv("FACE_SWAP_COMMAND", "stub")
+	processor, err := newFaceProcessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeStore()
+	task := testFaceTask(store)
+	w, notifier := newTestWorker(t, processor, store)
+
+	w.handle(context.Background(), &job{processor: processor, task: claimed(task)})
+
+	if task.Status != "completed" || task.Progress != 100 {
+		t.Errorf("задача: status=%s progress=%d, ожидалось completed и 100", task.Status, task.Progress)
+	}
+	if store.uploaded["face1"] != "media" {
+		t.Errorf("загружен результат %q, ожидалось исходное видео", store.uploaded["face1"])
+	}
+	want := []sentFile{{"1001", "sendVideo", "video", "media"}}
+	if fmt.Sprint(notifier.files) != fmt.Sprint(want) {
+		t.Errorf("отправлено %v, ожидалось %v", notifier.files, want)
+	}
+	if store.counters["owner1/face_replace_count"] != 1 || len(store.counters) != 1 {
+		t.Errorf("счетчики %v", store.counters)
+	}
+	if len(store.fails) != 0 {
+		t.Errorf("сбои %v", store.fails)
+	}
+}
+
+// Внешняя команда получает пути файлов вместо {face}, {media} и {output}, в том числе внутри аргумента
+func TestFaceSwapCommandTemplate(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("нет sh")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "swap.sh")
+	err := os.WriteFile(script, []byte("face=${1#--face=}\ncat \"$face\" \"$2\" > \"$3\"\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("FACE_SWAP_COMMAND", "sh "+script+" --face={face} {media} {output}")
+	processor, err := newFaceProcessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeStore()
+	task := testFaceTask(store)
+	w, notifier := newTestWorker(t, processor, store)
+
+	w.handle(context.Background(), &job{processor: processor, task: claimed(task)})
+
+	if task.Status != "completed" {
+		t.Fatalf("status=%s, error_message=%q", task.Status, task.ErrorMessage)
+	}
+	if store.uploaded["face1"] != "facemedia" {
+		t.Errorf("загружен результат %q, ожидалось facemedia", store.uploaded["face1"])
+	}
+	if len(notifier.files) != 1 || notifier.files[0].method != "sendVideo" {
+		t.Errorf("отправлено %v", notifier.files)
+	}
+}
+
+func TestFaceSwapCommandErrors(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("нет sh")
+	}
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.mp4")
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"ненулевой код", []string{"sh", "-c", "exit 1"}},
+		{"нет результата", []string{"true", "{output}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			swapper := &commandFaceSwapper{args: tt.args}
+			err := swapper.swapFace(context.Background(), "face.jpg", "media.mp4", output)
+			if err == nil {
+				t.Error("ожидалась ошибка")
+			}
+		})
+	}
+}
+
+func TestNewFaceProcessorWithoutCommand(t *testing.T) {
+	t.Setenv("FACE_SWAP_COMMAND", "")
+	if _, err := newFaceProcessor(); err == nil {
+		t.Error("обработчик создан без FACE_SWAP_COMMAND")
+	}
+}
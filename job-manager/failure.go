@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"pbclient"
+)
+
+// этапы обработки задачи, записываются в error_stage
+const (
+	stageDownload = "download" // скачивание входных файлов из pocketbase
+	stageProcess  = "process"  // ffmpeg или команда замены лица
+	stageUpload   = "upload"   // загрузка результата в output_media
+	stageNotify   = "notify"   // отправка результата владельцу
+)
+
+// максимальная длина error_message: вывод ffmpeg бывает очень длинным,
+// а причина ошибки обычно в его конце
+const maxErrorMessageLength = 4000
+
+// stageError - ошибка обработки с этапом, на котором она произошла
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return fmt.Sprintf("этап %s: %v", e.stage, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+func atStage(stage string, err error) error {
+	return &stageError{stage: stage, err: err}
+}
+
+// retryableError - временная ошибка (сеть, 5xx pocketbase, 429 Telegram),
+// после которой задачу стоит повторить
+type retryableError struct {
+	err        error
+	retryAfter time.Duration // минимальная пауза, если ее назвал сервер
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+func retryable(err error) error {
+	return &retryableError{err: err}
+}
+
+// Временная ли ошибка и сколько минимум ждать перед повтором
+func isRetryable(err error) (bool, time.Duration) {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return true, re.retryAfter
+	}
+
+	// токен можно получить заново, поэтому ошибку авторизации стоит повторить
+	if pbclient.IsAuth(err) {
+		return true, 0
+	}
+
+	// ответ pocketbase с кодом ошибки временный только для 429 и 5xx
+	if status := pbclient.StatusCode(err); status != 0 {
+		return retryableStatus(status), 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true, 0
+	}
+
+	return false, 0
+}
+
+// Временная ли ошибка для HTTP-статуса ответа
+func retryableStatus(status int) bool {
+	return status == 429 || status >= 500
+}
+
+// failure - итог неудачной попытки для записи в задачу
+type failure struct {
+	status      string // failed, dead или queued для повтора
+	stage       string
+	message     string
+	nextAttempt time.Time // только для status == "queued"
+}
+
+// Решение по неудачной попытке: постоянная ошибка - 'failed', временная - повтор
+// с экспоненциальной паузой, временная после maxAttempts попыток - 'dead'
+func decideFailure(err error, attempts int) failure {
+	stage, message := describeFailure(err)
+	f := failure{status: "failed", stage: stage, message: message}
+
+	ok, retryAfter := isRetryable(err)
+	if !ok {
+		return f
+	}
+	if attempts >= maxAttempts {
+		f.status = "dead"
+		return f
+	}
+
+	f.status = "queued"
+	f.nextAttempt = time.Now().Add(max(retryDelay(attempts), retryAfter))
+	return f
+}
+
+// Пауза перед повтором: retryBaseDelay * 2^(attempts-1), но не больше retryMaxDelay
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// Этап и текст ошибки для записи в задачу
+func describeFailure(err error) (stage, message string) {
+	stage = stageProcess
+	var se *stageError
+	if errors.As(err, &se) {
+		stage = se.stage
+		err = se.err
+	}
+
+	message = err.Error()
+	if len(message) > maxErrorMessageLength {
+		message = "..." + messageTail(message, maxErrorMessageLength)
+	}
+	return stage, message
+}
+
+// Последние limit байт строки; обрезка не разрывает многобайтовый символ
+func messageTail(message string, limit int) string {
+	start := len(message) - limit
+	for start < len(message) && !utf8.RuneStart(message[start]) {
+		start++
+	}
+	return message[start:]
+}
+
+// Причина сбоя для пользователя по этапу
+func failureReason(stage string) string {
+	switch stage {
+	case stageDownload:
+		return "не удалось получить исходные файлы"
+	case stageProcess:
+		return "не удалось обработать видео, возможно, формат файла не поддерживается"
+	case stageUpload:
+		return "не удалось сохранить результат"
+	case stageNotify:
+		return "не удалось отправить результат"
+	case "lease":
+		return "обработка несколько раз прерывалась"
+	default:
+		return "внутренняя ошибка"
+	}
+}
+
+// Сообщение владельцу о задаче в статусе 'failed' или 'dead'
+func failureText(taskID string, f failure) string {
+	reason := failureReason(f.stage)
+	if f.status == "dead" {
+		reason += fmt.Sprintf(" (повторные попытки исчерпаны: %d)", maxAttempts)
+	}
+	return fmt.Sprintf(
+		"❌ Задача %s не выполнена.\n"+
+			"Причина: %s.\n\n"+
+			"Повторить: /retry %s или кнопка ниже. Если ошибка повторяется, обратитесь в поддержку.",
+		taskID, reason, taskID,
+	)
+}
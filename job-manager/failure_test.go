@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDescribeFailureTruncatesOnRuneBoundary(t *testing.T) {
+	// кириллица - два байта на символ, нечетный сдвиг попадает в середину символа
+	for _, prefix := range []string{"", "x"} {
+		long := prefix + strings.Repeat("ошибка ffmpeg ", maxErrorMessageLength/10)
+		stage, message := describeFailure(&stageError{stage: stageUpload, err: errors.New(long)})
+
+		if stage != stageUpload {
+			t.Errorf("stage = %q, ожидался %q", stage, stageUpload)
+		}
+		if !utf8.ValidString(message) {
+			t.Fatalf("error_message содержит некорректный UTF-8: %q", message[:10])
+		}
+		if !strings.HasPrefix(message, "...") || !strings.HasSuffix(long, message[3:]) {
+			t.Errorf("ожидался конец сообщения после \"...\"")
+		}
+		if len(message)-3 > maxErrorMessageLength {
+			t.Errorf("длина %d больше %d", len(message)-3, maxErrorMessageLength)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Длительность видео по данным ffprobe
+func videoDuration(ctx context.Context, path string) (time.Duration, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка ffprobe: %v", err)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe вернул некорректную длительность %q", strings.TrimSpace(string(output)))
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Запуск ffmpeg с отчетом о прогрессе через -progress pipe:1.
+// total - длительность результата; если она неизвестна (0), прогресс не передается.
+func runFFmpeg(ctx context.Context, args []string, total time.Duration, progress func(percent int)) error {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("ошибка запуска ffmpeg: %v", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("ошибка запуска ffmpeg: %v", err)
+	}
+
+	readProgress(stdout, total, progress)
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("ошибка ffmpeg: %v, вывод: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Разбор блоков "key=value" из -progress: процент по out_time_us от total
+func readProgress(r io.Reader, total time.Duration, progress func(percent int)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || key != "out_time_us" || total <= 0 || progress == nil {
+			continue
+		}
+
+		// до первого кадра ffmpeg пишет N/A
+		microseconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || microseconds < 0 {
+			continue
+		}
+		percent := int(time.Duration(microseconds) * time.Microsecond * 100 / total)
+		progress(min(percent, 100))
+	}
+	// дочитываем вывод, чтобы ffmpeg не заблокировался на записи в pipe
+	io.Copy(io.Discard, r)
+}
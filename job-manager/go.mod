@@ -2,4 +2,8 @@ module job-manager
 
 go 1.23.3
 
-require github.com/joho/godotenv v1.5.1
+require github.com/joho/godotenv v1.5.1 // indirect
+
+require pbclient v0.0.0
+
+replace pbclient => ../pbclient
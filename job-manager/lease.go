@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Продление аренды задачи, пока она обрабатывается.
+// Возвращенный контекст отменяется с причиной errLeaseLost, если аренду забрали,
+// и errJobCancelled, если задачу отменил пользователь;
+// функция остановки (можно вызывать повторно) завершает продление перед записью итогового статуса.
+func startHeartbeat(parent context.Context, store JobStore, collection, taskID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	done := make(chan struct{})
+	interval := leaseDuration / 3
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := store.Heartbeat(collection, taskID)
+			if errors.Is(err, errJobCancelled) {
+				log.Printf("Задача %s отменена, обработка прерывается", taskID)
+				cancel(errJobCancelled)
+				return
+			}
+			if errors.Is(err, errLeaseLost) {
+				log.Printf("Аренда задачи %s потеряна, обработка прерывается", taskID)
+				cancel(errLeaseLost)
+				return
+			}
+			if err != nil {
+				// временная ошибка: следующая попытка до истечения аренды
+				log.Printf("Ошибка продления аренды задачи %s: %v", taskID, err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			cancel(nil)
+		})
+	}
+	return ctx, stop
+}
+
+// аренда задачи потеряна во время обработки
+func leaseLost(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errLeaseLost)
+}
+
+// задачу отменили во время обработки
+func jobCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errJobCancelled)
+}
+
+// Периодический возврат задач, чей воркер перестал продлевать аренду.
+// onDead вызывается для задач, исчерпавших попытки.
+func reapExpiredLeases(ctx context.Context, store JobStore, collection string, onDead func(collection, taskID string)) {
+	for ctx.Err() == nil {
+		requeued, dead, err := store.ReapExpired(collection)
+		if err != nil {
+			log.Printf("Ошибка возврата задач %s с истекшей арендой: %v", collection, err)
+		}
+		for _, id := range requeued {
+			log.Printf("Задача %s возвращена в очередь: аренда истекла", id)
+		}
+		for _, id := range dead {
+			log.Printf("Задача %s переведена в 'dead': исчерпано попыток %d", id, maxAttempts)
+			onDead(collection, id)
+		}
+
+		select {
+		case <-time.After(leaseDuration):
+		case <-ctx.Done():
+		}
+	}
+}
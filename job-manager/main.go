@@ -2,113 +2,49 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"syscall"
 	"time"
 
 	"mime/multipart"
+
+	"pbclient"
 )
 
 // Task - структура для хранения данных задачи
 type Task struct {
-	ID          string `json:"id"`
-	Owner       string `json:"owner"`
-	InputMedia  string `json:"input_media"`
-	OutputMedia string `json:"output_media"`
-	Status      string `json:"status"`
+	pbclient.Job
+	InputFace string `json:"input_face"` // только для face_jobs
 }
 
-// Основная функция обработки задачи
-func processCircleJobs() {
-	for {
-		task, err := fetchQueuedCircleJob("circle_jobs")
-		if err != nil {
-			log.Printf("Ошибка при получении задачи: %v", err)
-			continue
-		}
-		if task == nil {
-			wait()
-			continue
-		}
-
-		err = updateTaskStatus(task.ID, "processing")
-		if err != nil {
-			log.Printf("Ошибка смены статуса на 'processing' для задачи %s: %v", task.ID, err)
-			continue
-		}
-
-		err = processTask(task)
-		if err != nil {
-			log.Printf("Ошибка обработки задачи %s: %v", task.ID, err)
-			updateTaskStatus(task.ID, fmt.Sprintf("error. time: %v", time.Now()))
-			continue
-		}
-
-		err = updateTaskStatus(task.ID, "sending")
-		if err != nil {
-			log.Printf("Ошибка смены статуса на 'sending' для задачи %s: %v", task.ID, err)
-		}
-
-		err = notifyOwner(task)
-		if err != nil {
-			log.Printf("Ошибка отправки для задачи %s: %v", task.ID, err)
-		}
-
-		err = updateTaskStatus(task.ID, "completed")
-		if err != nil {
-			log.Printf("Ошибка смены статуса на 'completed' для задачи %s: %v", task.ID, err)
-		}
-
-	}
-}
-
-// Обработка задачи
-func processTask(task *Task) error {
-	if task.InputMedia == "" {
-		return fmt.Errorf("задача с ID %s не содержит ссылки на input_media", task.ID)
-	}
-
-	cacheDir := "cache"
-	err := os.MkdirAll(cacheDir, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("ошибка создания кэша: %v", err)
-	}
-
-	inputFilePath := filepath.Join(cacheDir, fmt.Sprintf("%s_input.mp4", task.ID))
-	outputFilePath := filepath.Join(cacheDir, fmt.Sprintf("%s_output.mp4", task.ID))
-	mediaUrl := fmt.Sprintf("%s/api/files/circle_jobs/%s/%s", pocketBaseUrl, task.ID, task.InputMedia)
-
-	err = downloadFile(mediaUrl, inputFilePath)
-	if err != nil {
-		return fmt.Errorf("ошибка скачивания файла: %v", err)
-	}
-
-	err = processVideo(inputFilePath, outputFilePath)
+// Скачивание файла
+func downloadFile(ctx context.Context, url, destination string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("ошибка обработки видео: %v", err)
+		return fmt.Errorf("ошибка создания запроса: %v", err)
 	}
 
-	err = uploadOutputMedia(task.ID, outputFilePath)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("ошибка загрузки кружка в бд: %v", err)
+		return retryable(fmt.Errorf("ошибка скачивания: %v", err))
 	}
+	defer resp.Body.Close()
 
-	return nil
-}
-
-// Скачивание файла
-func downloadFile(url, destination string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("ошибка скачивания: %v", err)
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("ошибка скачивания: статус %d", resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return retryable(err)
+		}
+		return err
 	}
-	defer resp.Body.Close()
 
 	file, err := os.Create(destination)
 	if err != nil {
@@ -118,51 +54,19 @@ func downloadFile(url, destination string) error {
 
 	_, err = io.Copy(file, resp.Body)
 	if err != nil {
-		return fmt.Errorf("ошибка сохранения файла: %v", err)
+		return retryable(fmt.Errorf("ошибка сохранения файла: %v", err))
 	}
 
 	return nil
 }
 
-// Обработка файла
-func processVideo(inputPath, outputPath string) error {
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", inputPath,
-		"-vf", "crop=min(iw\\,ih):min(iw\\,ih):(iw-min(iw\\,ih))/2:(ih-min(iw\\,ih))/2,scale=512:512",
-		"-r", "30",
-		"-t", "60",
-		"-c:v", "libx264",
-		"-preset", "fast",
-		"-crf", "23",
-		outputPath,
-	)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("ошибка ffmpeg: %v, вывод: %s", err, string(output))
-	}
-
-	return nil
-}
-
-// Отправка готового видеосообщения владельцу через Telegram API
-func notifyOwner(task *Task) error {
-	if task.Owner == "" {
-		return fmt.Errorf("задача с ID %s не содержит корректного owner", task.ID)
-	}
-
-	ownerTGID, err := getOwnerTGID(task.Owner)
-	if err != nil {
-		return fmt.Errorf("ошибка получения Telegram ID владельца задачи %s: %v", task.ID, err)
-	}
-
-	outputFilePath := filepath.Join("cache", fmt.Sprintf("%s_output.mp4", task.ID))
-	if _, err := os.Stat(outputFilePath); err != nil {
+// Отправка файла в чат через метод Telegram API (sendVideoNote, sendVideo, ...)
+func sendTelegramFile(chatID, method, field, filePath string) error {
+	if _, err := os.Stat(filePath); err != nil {
 		return fmt.Errorf("файл для отправки не найден: %v", err)
 	}
 
-	file, err := os.Open(outputFilePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("ошибка открытия файла: %v", err)
 	}
@@ -177,12 +81,12 @@ func notifyOwner(task *Task) error {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
-	err = writer.WriteField("chat_id", ownerTGID)
+	err = writer.WriteField("chat_id", chatID)
 	if err != nil {
 		return fmt.Errorf("ошибка добавления поля chat_id: %v", err)
 	}
 
-	filePart, err := writer.CreateFormFile("video_note", filepath.Base(outputFilePath))
+	filePart, err := writer.CreateFormFile(field, filepath.Base(filePath))
 	if err != nil {
 		return fmt.Errorf("ошибка добавления файла в запрос: %v", err)
 	}
@@ -196,7 +100,7 @@ func notifyOwner(task *Task) error {
 		return fmt.Errorf("ошибка закрытия записи multipart данных: %v", err)
 	}
 
-	url := fmt.Sprintf("%s/bot%s/sendVideoNote", BOT_ENDPOINT, os.Getenv("TELEGRAM_APITOKEN"))
+	url := fmt.Sprintf("%s/bot%s/%s", BOT_ENDPOINT, os.Getenv("TELEGRAM_APITOKEN"), method)
 
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
@@ -208,45 +112,107 @@ func notifyOwner(task *Task) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("ошибка отправки запроса Telegram API: %v", err)
+		return retryable(fmt.Errorf("ошибка отправки запроса Telegram API: %v", err))
 	}
 	defer resp.Body.Close()
 
-	// Проверяем ответ от Telegram API
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("ошибка чтения ответа Telegram API: %v", err)
+	return checkTelegramResponse(resp)
+}
+
+// inlineButton - inline-кнопка под сообщением, нажатие обрабатывает telegram-bot
+type inlineButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// Отправка текстового сообщения в чат, кнопки - по одной в ряд
+func sendTelegramMessage(chatID, text string, buttons []inlineButton) error {
+	message := map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("ошибка в Telegram API. Код %d: %s", resp.StatusCode, string(respBody))
+	if len(buttons) > 0 {
+		rows := make([][]inlineButton, len(buttons))
+		for i, button := range buttons {
+			rows[i] = []inlineButton{button}
+		}
+		message["reply_markup"] = map[string]interface{}{"inline_keyboard": rows}
 	}
 
-	log.Printf("Видеосообщение отправлено владельцу задачи %s (Telegram ID: %s).", task.ID, ownerTGID)
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации сообщения: %v", err)
+	}
 
-	// Увеличиваем счетчик кружков (circle_count) для владельца
-	tgid, err := strconv.Atoi(ownerTGID)
+	url := fmt.Sprintf("%s/bot%s/sendMessage", BOT_ENDPOINT, BOT_TOKEN)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
 	if err != nil {
-		return fmt.Errorf("ошибка преобразования telegram id в int: %s", err)
+		return retryable(fmt.Errorf("ошибка отправки запроса Telegram API: %v", err))
 	}
-	err = incrementCircleCount(tgid)
+	defer resp.Body.Close()
+
+	return checkTelegramResponse(resp)
+}
+
+// Проверка ответа от Telegram API
+func checkTelegramResponse(resp *http.Response) error {
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("ошибка обновления circle_count для владельца задачи %s: %v", task.ID, err)
+		return retryable(fmt.Errorf("ошибка чтения ответа Telegram API: %v", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("ошибка в Telegram API. Код %d: %s", resp.StatusCode, string(respBody))
+		if retryableStatus(resp.StatusCode) {
+			return &retryableError{err: err, retryAfter: telegramRetryAfter(respBody)}
+		}
+		return err
 	}
 
 	return nil
 }
 
-func wait() {
-	<-time.After(10 * time.Second)
+// Пауза из ответа Telegram 429: {"parameters": {"retry_after": секунды}}
+func telegramRetryAfter(respBody []byte) time.Duration {
+	var response struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return 0
+	}
+	return time.Duration(response.Parameters.RetryAfter) * time.Second
 }
 
 func main() {
 	BOT_TOKEN, _, BOT_ENDPOINT = LoadEnvironment()
 
-	err := authenticatePocketBase()
+	processors, err := loadProcessors(jobTypes)
+	if err != nil {
+		log.Fatalf("Ошибка настройки обработчиков: %v", err)
+	}
+
+	err = pb.Authenticate()
 	if err != nil {
 		log.Fatalf("Ошибка аутентификации: %v", err)
 	}
 
-	processCircleJobs()
+	// SIGTERM от docker или Ctrl+C: прекращаем захват задач и завершаем текущие
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	w := newWorker(processors)
+	var collections []string
+	for _, processor := range processors {
+		go reapExpiredLeases(ctx, w.store, processor.Collection(), w.notifyLeaseDead)
+		collections = append(collections, processor.Collection())
+		log.Printf("Запущен обработчик задач %s", processor.Collection())
+	}
+
+	w.events = newJobEvents(collections, w.cancel)
+	go w.events.run(ctx)
+
+	log.Printf("Воркер %s: задач одновременно %d, из них обработок %d", workerID, workerConcurrency, processConcurrency)
+	w.run(ctx)
+	log.Printf("Воркер %s остановлен", workerID)
 }
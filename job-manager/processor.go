@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Processor - тип задач job-manager: коллекция в pocketbase, входные файлы,
+// обработка и способ отправки результата владельцу.
+// Захват, аренда, загрузка и уведомления общие для всех типов и выполняются worker.
+type Processor interface {
+	// коллекция задач в pocketbase
+	Collection() string
+	// файловые поля задачи, которые нужно скачать перед обработкой: поле -> имя файла
+	Inputs(task *Task) (map[string]string, error)
+	// обработка скачанных файлов (поле -> путь) в outputPath;
+	// progress (0-100) можно вызывать часто, запись в pocketbase ограничивает worker
+	Process(ctx context.Context, inputs map[string]string, outputPath string, progress func(percent int)) error
+	// метод Telegram API и поле файла для отправки результата
+	Delivery() (method, field string)
+	// счетчик пользователя, который увеличивается после отправки результата
+	Counter() string
+}
+
+// конструкторы обработчиков по имени типа задач в JOB_TYPES
+var processorRegistry = map[string]func() (Processor, error){}
+
+func registerProcessor(name string, constructor func() (Processor, error)) {
+	if _, ok := processorRegistry[name]; ok {
+		log.Fatalf("processor %s registered twice", name)
+	}
+	processorRegistry[name] = constructor
+}
+
+// Обработчики для типов задач из JOB_TYPES (через запятую)
+func loadProcessors(jobTypes string) ([]Processor, error) {
+	var processors []Processor
+	for _, name := range strings.Split(jobTypes, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		constructor, ok := processorRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный тип задач %q, доступны: %s", name, strings.Join(registeredProcessors(), ", "))
+		}
+		processor, err := constructor()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания обработчика %s: %v", name, err)
+		}
+		processors = append(processors, processor)
+	}
+
+	if len(processors) == 0 {
+		return nil, fmt.Errorf("не задано ни одного типа задач")
+	}
+	return processors, nil
+}
+
+func registeredProcessors() []string {
+	names := make([]string, 0, len(processorRegistry))
+	for name := range processorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JobStore - операции worker с задачами и пользователями в pocketbase
+type JobStore interface {
+	ClaimQueued(collection string) (*Task, error)
+	Heartbeat(collection, taskID string) error
+	ReapExpired(collection string) (requeued, dead []string, err error)
+	DownloadFile(ctx context.Context, collection, taskID, fileName, destination string) error
+	UploadOutput(collection, taskID, filePath string) error
+	UpdateStatus(collection, taskID, status string) error
+	UpdateProgress(collection, taskID string, percent int) error
+	Fail(collection, taskID string, f failure) error
+	Get(collection, taskID string) (*Task, error)
+	Release(collection, taskID string) error
+	OwnerTGID(ownerID string) (string, error)
+	IncrementCounter(userID, field string) error
+}
+
+// Notifier - отправка файлов и сообщений пользователю
+type Notifier interface {
+	SendFile(chatID, method, field, filePath string) error
+	SendMessage(chatID, text string, buttons []inlineButton) error
+}
+
+// pocketBaseStore - JobStore поверх REST API pocketbase;
+// workerID записывается в захваченные задачи и проверяется при продлении аренды
+type pocketBaseStore struct {
+	workerID string
+}
+
+func (s *pocketBaseStore) ClaimQueued(collection string) (*Task, error) {
+	return claimQueuedJob(collection, s.workerID)
+}
+
+func (s *pocketBaseStore) Heartbeat(collection, taskID string) error {
+	return heartbeatTask(collection, taskID, s.workerID)
+}
+
+func (s *pocketBaseStore) ReapExpired(collection string) ([]string, []string, error) {
+	return reapExpiredTasks(collection)
+}
+
+func (s *pocketBaseStore) DownloadFile(ctx context.Context, collection, taskID, fileName, destination string) error {
+	return downloadFile(ctx, pb.FileURL(collection, taskID, fileName), destination)
+}
+
+func (s *pocketBaseStore) UploadOutput(collection, taskID, filePath string) error {
+	return uploadOutputMedia(collection, taskID, filePath)
+}
+
+func (s *pocketBaseStore) UpdateStatus(collection, taskID, status string) error {
+	return updateTaskStatus(collection, taskID, status)
+}
+
+func (s *pocketBaseStore) UpdateProgress(collection, taskID string, percent int) error {
+	return updateTaskProgress(collection, taskID, percent)
+}
+
+func (s *pocketBaseStore) Fail(collection, taskID string, f failure) error {
+	return failTask(collection, taskID, f)
+}
+
+func (s *pocketBaseStore) Get(collection, taskID string) (*Task, error) {
+	return getTask(collection, taskID)
+}
+
+func (s *pocketBaseStore) Release(collection, taskID string) error {
+	return releaseTask(collection, taskID, s.workerID)
+}
+
+func (s *pocketBaseStore) OwnerTGID(ownerID string) (string, error) {
+	return getOwnerTGID(ownerID)
+}
+
+func (s *pocketBaseStore) IncrementCounter(userID, field string) error {
+	return incrementUserCounter(userID, field)
+}
+
+// telegramNotifier - Notifier через Telegram Bot API
+type telegramNotifier struct{}
+
+func (n *telegramNotifier) SendFile(chatID, method, field, filePath string) error {
+	return sendTelegramFile(chatID, method, field, filePath)
+}
+
+func (n *telegramNotifier) SendMessage(chatID, text string, buttons []inlineButton) error {
+	return sendTelegramMessage(chatID, text, buttons)
+}
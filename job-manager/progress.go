@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// как часто прогресс обработки записывается в задачу: ffmpeg сообщает его несколько раз в секунду
+const progressInterval = 3 * time.Second
+
+// progressReporter - запись прогресса обработки в pocketbase не чаще progressInterval.
+// report только запоминает значение, поэтому не задерживает чтение вывода ffmpeg.
+type progressReporter struct {
+	store      JobStore
+	collection string
+	taskID     string
+
+	latest atomic.Int32
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func startProgress(store JobStore, collection, taskID string) *progressReporter {
+	r := &progressReporter{
+		store:      store,
+		collection: collection,
+		taskID:     taskID,
+		done:       make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// Новое значение прогресса; до загрузки результата не больше 99
+func (r *progressReporter) report(percent int) {
+	r.latest.Store(int32(max(0, min(percent, 99))))
+}
+
+// Остановка записи прогресса
+func (r *progressReporter) stop() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+func (r *progressReporter) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	var sent int32
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.done:
+			return
+		}
+
+		percent := r.latest.Load()
+		if percent == sent {
+			continue
+		}
+		// отмену и потерю аренды замечает heartbeat, сбой записи прогресса обработку не прерывает
+		err := r.store.UpdateProgress(r.collection, r.taskID, int(percent))
+		if err != nil {
+			log.Printf("Ошибка записи прогресса задачи %s: %v", r.taskID, err)
+			continue
+		}
+		sent = percent
+	}
+}
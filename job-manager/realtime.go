@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"pbclient"
+)
+
+// интервал опроса очереди без realtime и страховочный опрос при активной подписке:
+// задачи с next_attempt_at становятся доступны по времени, без событий
+const (
+	pollInterval         = 10 * time.Second
+	realtimePollInterval = time.Minute
+)
+
+// jobEvents - подписка на realtime API pocketbase (pbclient.Realtime).
+// Создание задачи или возврат ее в очередь будит цикл захвата сразу,
+// отмена задачи передается в onCancel, чтобы прервать ее обработку без ожидания heartbeat.
+// Пока подписки нет, worker опрашивает очередь каждые pollInterval.
+type jobEvents struct {
+	realtime *pbclient.Realtime
+	wake     chan struct{}
+	onCancel func(taskID string)
+}
+
+func newJobEvents(collections []string, onCancel func(taskID string)) *jobEvents {
+	e := &jobEvents{
+		realtime: pb.Realtime(collections...),
+		wake:     make(chan struct{}, 1),
+		onCancel: onCancel,
+	}
+
+	e.realtime.OnConnect = func() {
+		log.Printf("Realtime: подписка на %s", strings.Join(collections, ", "))
+		// события могли прийти, пока подписки не было
+		e.notify()
+	}
+	e.realtime.OnDisconnect = func(err error, retryIn time.Duration) {
+		log.Printf("Realtime: подписка прервана, опрос каждые %s, переподключение через %s: %v", pollInterval, retryIn, err)
+	}
+	e.realtime.OnEvent = e.handle
+	return e
+}
+
+// Подписка с переподключением, пока не отменен ctx
+func (e *jobEvents) run(ctx context.Context) {
+	e.realtime.Run(ctx)
+}
+
+// Подписка активна
+func (e *jobEvents) connected() bool {
+	return e.realtime.Connected()
+}
+
+// Интервал опроса очереди: при активной подписке только страховочный
+func (e *jobEvents) interval() time.Duration {
+	if e.connected() {
+		return realtimePollInterval
+	}
+	return pollInterval
+}
+
+func (e *jobEvents) handle(topic string, event pbclient.RecordEvent) {
+	var task Task
+	if err := event.Decode(&task); err != nil {
+		log.Printf("Realtime: ошибка разбора задачи %s: %v", topic, err)
+		return
+	}
+
+	if task.Status == "queued" && (event.Action == "create" || event.Action == "update") {
+		e.notify()
+	}
+	if task.Status == "cancelled" && event.Action == "update" && e.onCancel != nil {
+		e.onCancel(task.ID)
+	}
+}
+
+func (e *jobEvents) notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
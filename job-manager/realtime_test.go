@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pbclient"
+)
+
+// realtimeStub - /api/realtime pocketbase: после PB_CONNECT и подписки отправляет события
+// из канала events по одному, закрытие канала обрывает поток
+func realtimeStub(events <-chan string) http.Handler {
+	subscribed := make(chan struct{}, 1)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			subscribed <- struct{}{}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		fmt.Fprint(w, "event:PB_CONNECT\ndata:{\"clientId\":\"c1\"}\n\n")
+		flusher.Flush()
+		select {
+		case <-subscribed:
+		case <-r.Context().Done():
+			return
+		}
+
+		for event := range events {
+			fmt.Fprint(w, event)
+			flusher.Flush()
+		}
+	})
+}
+
+func jobEvent(action, id, status string) string {
+	return fmt.Sprintf("event:circle_jobs\ndata:{\"action\":%q,\"record\":{\"id\":%q,\"status\":%q}}\n\n", action, id, status)
+}
+
+// Ожидание условия с таймаутом
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectWake(t *testing.T, e *jobEvents, want bool) {
+	t.Helper()
+	timeout := 200 * time.Millisecond
+	if want {
+		timeout = 5 * time.Second
+	}
+	select {
+	case <-e.wake:
+		if !want {
+			t.Fatal("цикл захвата разбужен без новой задачи")
+		}
+	case <-time.After(timeout):
+		if want {
+			t.Fatal("цикл захвата не разбужен")
+		}
+	}
+}
+
+func TestJobEventsWakeCancelAndFallback(t *testing.T) {
+	events := make(chan string)
+	server := httptest.NewServer(realtimeStub(events))
+	defer server.Close()
+	pb = pbclient.New(server.URL, "admin@example.com", "password")
+
+	cancelled := make(chan string, 1)
+	e := newJobEvents([]string{pbclient.CircleJobsCollection}, func(taskID string) { cancelled <- taskID })
+	if e.interval() != pollInterval {
+		t.Fatalf("без подписки интервал %s, ожидался %s", e.interval(), pollInterval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.run(ctx)
+
+	eventually(t, "подписка", e.connected)
+	if e.interval() != realtimePollInterval {
+		t.Errorf("при подписке интервал %s, ожидался %s", e.interval(), realtimePollInterval)
+	}
+	// OnConnect будит цикл: задачи могли появиться до подписки
+	expectWake(t, e, true)
+
+	events <- jobEvent("update", "job0", "processing")
+	expectWake(t, e, false)
+
+	events <- jobEvent("create", "job1", "queued")
+	expectWake(t, e, true)
+
+	events <- jobEvent("update", "job2", "cancelled")
+	select {
+	case id := <-cancelled:
+		if id != "job2" {
+			t.Errorf("onCancel(%q), ожидался job2", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("onCancel не вызван")
+	}
+
+	// обрыв потока: worker возвращается к частому опросу
+	close(events)
+	eventually(t, "обрыв подписки", func() bool { return !e.connected() })
+	if e.interval() != pollInterval {
+		t.Errorf("после обрыва интервал %s, ожидался %s", e.interval(), pollInterval)
+	}
+}
@@ -1,96 +1,89 @@
 package main
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
+	"time"
 
-	"github.com/joho/godotenv"
+	"pbclient"
 )
 
-// PocketBase global credentials
-var pocketBaseUrl string
-var email string
-var password string
-var authToken string
+// PocketBase client
+var pb *pbclient.Client
 
 // tgbot globals
 var BOT_TOKEN string
 var BOT_ENDPOINT string
 
-// just for sending search requests to pocketbase
-func sendAuthorizedRequest(method, url string, payload []byte) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
+// идентификатор этого экземпляра job-manager, записывается в worker_id захваченных задач
+var workerID string
 
-	if authToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
-	}
+// типы задач, которые обслуживает этот экземпляр (circle, face)
+var jobTypes string
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// аренда задач: срок аренды и число попыток до перевода задачи в "dead"
+var leaseDuration time.Duration
+var maxAttempts int
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// пул обработки: задач одновременно и одновременных запусков ffmpeg / замены лица
+var workerConcurrency int
+var processConcurrency int
 
-	return body, nil
-}
+// сколько при остановке ждать завершения текущих задач
+var shutdownTimeout time.Duration
+
+// пауза перед повтором задачи после временной ошибки растет от retryBaseDelay до retryMaxDelay
+var retryBaseDelay time.Duration
+var retryMaxDelay time.Duration
 
 // loading env variables from .env or system environment
 func LoadEnvironment() (string, bool, string) {
-	if os.Getenv("DOCKER_BUILD") == `` {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatalf("Error loading .env file")
-		}
-	}
+	env := pbclient.LoadEnvironment()
+	pb = env.PocketBase()
 
-	// load telegram token
-	bot_token := os.Getenv("TELEGRAM_APITOKEN")
-	if bot_token == `` {
-		log.Fatal("empty telegram api token loaded, check TELEGRAM_APITOKEN value")
+	// worker
+	workerID = os.Getenv("WORKER_ID")
+	if workerID == `` {
+		workerID = newWorkerID()
 	}
 
-	// telegram bot debug mode
-	var bot_debug bool
-	if os.Getenv("BOT_DEBUG") == `true` {
-		bot_debug = true
-	} else {
-		bot_debug = false
+	// по умолчанию замена лиц включается вместе с настроенным бэкендом
+	jobTypes = os.Getenv("JOB_TYPES")
+	if jobTypes == `` {
+		jobTypes = "circle"
+		if os.Getenv("FACE_SWAP_COMMAND") != `` {
+			jobTypes = "circle,face"
+		}
 	}
 
-	bot_endpoint := os.Getenv("TELEGRAM_API")
-	if bot_endpoint == `` {
-		bot_endpoint = "https://api.telegram.org"
-	}
+	workerConcurrency = pbclient.IntEnv("WORKER_CONCURRENCY", 1)
+	processConcurrency = pbclient.IntEnv("FFMPEG_CONCURRENCY", workerConcurrency)
 
-	// pocketbase
-	pocketBaseUrl = os.Getenv("POCKETBASE_URL")
-	if pocketBaseUrl == `` {
-		log.Fatal("empty pocketbase url loaded, check POCKETBASE_URL value")
-	}
+	shutdownTimeout = time.Duration(pbclient.IntEnv("SHUTDOWN_TIMEOUT", 30)) * time.Second
+
+	leaseDuration = time.Duration(pbclient.IntEnv("LEASE_SECONDS", 60)) * time.Second
+	maxAttempts = pbclient.IntEnv("MAX_ATTEMPTS", 3)
+	retryBaseDelay = time.Duration(pbclient.IntEnv("RETRY_BASE_SECONDS", 30)) * time.Second
+	retryMaxDelay = time.Duration(pbclient.IntEnv("RETRY_MAX_SECONDS", 1800)) * time.Second
+
+	return env.BotToken, env.BotDebug, env.BotEndpoint
+}
 
-	email = os.Getenv("POCKETBASE_LOGIN")
-	if email == `` {
-		log.Fatal("empty pocketbase login loaded. env is not correct or configuration is insecure")
+// уникальный id воркера: имя хоста, pid и случайный суффикс,
+// чтобы перезапущенный контейнер не считал своими задачи прошлого процесса
+func newWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == `` {
+		hostname = "job-manager"
 	}
 
-	password = os.Getenv("POCKETBASE_PASSWORD")
-	if password == `` {
-		log.Fatal("empty pocketbase password loaded. env is not correct or configuration is insecure")
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Fatalf("не удалось сгенерировать worker id: %v", err)
 	}
 
-	return bot_token, bot_debug, bot_endpoint
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
 }
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"pbclient"
+)
+
+// job - захваченная задача и обработчик ее типа
+type job struct {
+	processor Processor
+	task      *Task
+}
+
+// worker - общий цикл захвата задач всех типов и пул их обработки.
+// Одновременно обрабатывается до concurrency задач, из них не больше
+// len(processSlots) держат ffmpeg или команду замены лица.
+type worker struct {
+	processors   []Processor
+	store        JobStore
+	notifier     Notifier
+	cacheDir     string
+	concurrency  int
+	processSlots chan struct{}
+	events       *jobEvents // nil - только опрос очереди
+
+	// отмена обработки текущих задач по ID, для событий об отмене задачи пользователем
+	runningMu sync.Mutex
+	running   map[string]context.CancelCauseFunc
+}
+
+func newWorker(processors []Processor) *worker {
+	return &worker{
+		processors:   processors,
+		store:        &pocketBaseStore{workerID: workerID},
+		notifier:     &telegramNotifier{},
+		cacheDir:     "cache",
+		concurrency:  workerConcurrency,
+		processSlots: make(chan struct{}, processConcurrency),
+		running:      make(map[string]context.CancelCauseFunc),
+	}
+}
+
+// job-manager останавливается, обработка прервана по истечении shutdownTimeout
+var errShutdown = errors.New("job-manager останавливается")
+
+// Основной цикл: задача захватывается только при свободном месте в пуле,
+// чтобы не держать аренду задач, которые некому обрабатывать.
+// После отмены ctx новые задачи не захватываются, а run ждет текущие (см. drain).
+func (w *worker) run(ctx context.Context) {
+	// задачи не зависят от ctx: при остановке им дается время завершиться
+	jobsCtx, cancelJobs := context.WithCancelCause(context.Background())
+	defer cancelJobs(nil)
+
+	var inFlight sync.WaitGroup
+	slots := make(chan struct{}, w.concurrency)
+	next := 0
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		j, err := w.claim(next)
+		if err != nil || j == nil {
+			<-slots
+			if err != nil {
+				log.Printf("Ошибка при получении задачи: %v", err)
+			}
+			w.wait(ctx)
+			continue
+		}
+		// следующий захват начинается со следующего типа задач
+		next++
+
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			defer func() { <-slots }()
+			w.handle(jobsCtx, j)
+		}()
+	}
+
+	w.drain(&inFlight, cancelJobs)
+}
+
+// Ожидание текущих задач при остановке. Если они не успели за shutdownTimeout,
+// их контекст отменяется: ffmpeg завершается, а задачи возвращаются в очередь.
+func (w *worker) drain(inFlight *sync.WaitGroup, cancelJobs context.CancelCauseFunc) {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	log.Printf("Остановка: новые задачи не захватываются, ожидание текущих до %s", shutdownTimeout)
+	select {
+	case <-done:
+		return
+	case <-time.After(shutdownTimeout):
+	}
+
+	log.Printf("Текущие задачи не завершились за %s, обработка прерывается", shutdownTimeout)
+	cancelJobs(errShutdown)
+	<-done
+}
+
+// Пауза перед следующим захватом: до события realtime о новой задаче,
+// следующего опроса очереди или остановки
+func (w *worker) wait(ctx context.Context) {
+	interval := pollInterval
+	var wake <-chan struct{}
+	if w.events != nil {
+		wake = w.events.wake
+		interval = w.events.interval()
+	}
+
+	select {
+	case <-time.After(interval):
+	case <-wake:
+	case <-ctx.Done():
+	}
+}
+
+// Захват задачи любого типа, начиная с processors[start] по кругу
+func (w *worker) claim(start int) (*job, error) {
+	for i := range w.processors {
+		processor := w.processors[(start+i)%len(w.processors)]
+		collection := processor.Collection()
+
+		// задача переводится в 'processing' атомарно при захвате
+		task, err := w.store.ClaimQueued(collection)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", collection, err)
+		}
+		if task != nil {
+			log.Printf("Задача %s %s захвачена воркером %s", collection, task.ID, workerID)
+			return &job{processor: processor, task: task}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Обработка захваченной задачи, пока воркер держит ее аренду
+func (w *worker) handle(parent context.Context, j *job) {
+	collection := j.processor.Collection()
+	ctx := w.track(parent, j.task.ID)
+	defer w.untrack(j.task.ID)
+	ctx, stopHeartbeat := startHeartbeat(ctx, w.store, collection, j.task.ID)
+	defer stopHeartbeat()
+	defer w.cleanup(j.task)
+
+	outputPath, err := w.process(ctx, j)
+	if jobCancelled(ctx) || errors.Is(err, errJobCancelled) {
+		log.Printf("Задача %s отменена пользователем, обработка прервана", j.task.ID)
+		return
+	}
+	if leaseLost(ctx) {
+		log.Printf("Задача %s оставлена: аренда перешла к другому воркеру", j.task.ID)
+		return
+	}
+	if errors.Is(context.Cause(ctx), errShutdown) {
+		stopHeartbeat()
+		w.release(j)
+		return
+	}
+	if err == nil {
+		// UploadOutput уже перевела задачу в 'sending'
+		err = w.notify(j, outputPath)
+	}
+	if err != nil {
+		log.Printf("Ошибка обработки задачи %s: %v", j.task.ID, err)
+		stopHeartbeat()
+		w.fail(j, err)
+		return
+	}
+
+	stopHeartbeat()
+	err = w.store.UpdateStatus(collection, j.task.ID, "completed")
+	if err != nil {
+		log.Printf("Ошибка смены статуса на 'completed' для задачи %s: %v", j.task.ID, err)
+	}
+}
+
+// Контекст задачи, который можно отменить через cancel
+func (w *worker) track(parent context.Context, taskID string) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+	w.running[taskID] = cancel
+	return ctx
+}
+
+func (w *worker) untrack(taskID string) {
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+	if cancel, ok := w.running[taskID]; ok {
+		cancel(nil)
+		delete(w.running, taskID)
+	}
+}
+
+// Прерывание обработки задачи, которую отменил пользователь: ffmpeg завершается вместе с контекстом
+func (w *worker) cancel(taskID string) {
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+	if cancel, ok := w.running[taskID]; ok {
+		log.Printf("Задача %s отменена пользователем", taskID)
+		cancel(errJobCancelled)
+	}
+}
+
+// Возврат прерванной при остановке задачи в очередь
+func (w *worker) release(j *job) {
+	err := w.store.Release(j.processor.Collection(), j.task.ID)
+	if err != nil {
+		log.Printf("Ошибка возврата задачи %s в очередь: %v", j.task.ID, err)
+		return
+	}
+	log.Printf("Задача %s возвращена в очередь: job-manager останавливается", j.task.ID)
+}
+
+// Удаление файлов задачи из кэша
+func (w *worker) cleanup(task *Task) {
+	paths, err := filepath.Glob(filepath.Join(w.cacheDir, task.ID+"_*"))
+	if err != nil {
+		log.Printf("Ошибка поиска файлов задачи %s в кэше: %v", task.ID, err)
+		return
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Ошибка удаления %s: %v", path, err)
+		}
+	}
+}
+
+// Запись сбоя задачи: 'failed', 'dead' или возврат в очередь для повтора
+func (w *worker) fail(j *job, err error) {
+	f := decideFailure(err, j.task.Attempts)
+	switch f.status {
+	case "queued":
+		log.Printf("Задача %s будет повторена после %s (попытка %d из %d)", j.task.ID, f.nextAttempt.Format(time.RFC3339), j.task.Attempts, maxAttempts)
+	case "dead":
+		log.Printf("Задача %s переведена в 'dead': исчерпано попыток %d", j.task.ID, maxAttempts)
+	}
+
+	err = w.store.Fail(j.processor.Collection(), j.task.ID, f)
+	if errors.Is(err, errJobCancelled) {
+		log.Printf("Задача %s отменена пользователем, сбой не записан", j.task.ID)
+		return
+	}
+	if pbclient.IsNotFound(err) {
+		log.Printf("Задача %s удалена из бд, сбой не записан", j.task.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка записи сбоя задачи %s: %v", j.task.ID, err)
+		return
+	}
+
+	// о повторе пользователю не сообщаем, задача еще может выполниться
+	if f.status != "queued" {
+		w.notifyFailure(j.processor.Collection(), j.task, f)
+	}
+}
+
+// Сообщение владельцу о сбое задачи с кнопкой повтора (/retry в telegram-bot)
+func (w *worker) notifyFailure(collection string, task *Task, f failure) {
+	if task.Owner == "" {
+		return
+	}
+
+	ownerTGID, err := w.store.OwnerTGID(task.Owner)
+	if err != nil {
+		log.Printf("Ошибка получения Telegram ID владельца задачи %s: %v", task.ID, err)
+		return
+	}
+
+	retry := inlineButton{
+		Text:         "🔁 Повторить",
+		CallbackData: fmt.Sprintf("retry:%s:%s", collection, task.ID),
+	}
+	err = w.notifier.SendMessage(ownerTGID, failureText(task.ID, f), []inlineButton{retry})
+	if err != nil {
+		log.Printf("Ошибка отправки сообщения о сбое задачи %s: %v", task.ID, err)
+		return
+	}
+	log.Printf("Владелец задачи %s уведомлен о сбое (Telegram ID: %s).", task.ID, ownerTGID)
+}
+
+// Сообщение владельцу задачи, которую перевели в 'dead' по истечении аренды
+func (w *worker) notifyLeaseDead(collection, taskID string) {
+	task, err := w.store.Get(collection, taskID)
+	if err != nil {
+		log.Printf("Ошибка получения задачи %s для уведомления о сбое: %v", taskID, err)
+		return
+	}
+	w.notifyFailure(collection, task, failure{status: "dead", stage: task.ErrorStage, message: task.ErrorMessage})
+}
+
+// Скачивание входных файлов, обработка и загрузка результата в output_media
+func (w *worker) process(ctx context.Context, j *job) (string, error) {
+	collection := j.processor.Collection()
+	task := j.task
+
+	inputs, err := j.processor.Inputs(task)
+	if err != nil {
+		return "", atStage(stageDownload, err)
+	}
+
+	err = os.MkdirAll(w.cacheDir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания кэша: %v", err)
+	}
+
+	paths := make(map[string]string, len(inputs))
+	for field, fileName := range inputs {
+		path := filepath.Join(w.cacheDir, fmt.Sprintf("%s_%s%s", task.ID, field, filepath.Ext(fileName)))
+		err = w.store.DownloadFile(ctx, collection, task.ID, fileName, path)
+		if err != nil {
+			return "", atStage(stageDownload, fmt.Errorf("ошибка скачивания %s: %w", field, err))
+		}
+		paths[field] = path
+	}
+
+	outputPath := filepath.Join(w.cacheDir, fmt.Sprintf("%s_output.mp4", task.ID))
+	err = w.runProcess(ctx, j, paths, outputPath)
+	if err != nil {
+		return "", atStage(stageProcess, err)
+	}
+
+	// не загружаем результат, если аренду задачи уже потеряли
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	err = w.store.UploadOutput(collection, task.ID, outputPath)
+	if err != nil {
+		return "", atStage(stageUpload, fmt.Errorf("ошибка загрузки результата в бд: %w", err))
+	}
+
+	return outputPath, nil
+}
+
+// Обработка с ограничением числа одновременных ffmpeg / команд замены лица
+func (w *worker) runProcess(ctx context.Context, j *job, inputs map[string]string, outputPath string) error {
+	select {
+	case w.processSlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-w.processSlots }()
+
+	progress := startProgress(w.store, j.processor.Collection(), j.task.ID)
+	defer progress.stop()
+
+	return j.processor.Process(ctx, inputs, outputPath, progress.report)
+}
+
+// Отправка результата владельцу и увеличение его счетчика
+func (w *worker) notify(j *job, outputPath string) error {
+	task := j.task
+	if task.Owner == "" {
+		return atStage(stageNotify, fmt.Errorf("задача с ID %s не содержит корректного owner", task.ID))
+	}
+
+	ownerTGID, err := w.store.OwnerTGID(task.Owner)
+	if err != nil {
+		return atStage(stageNotify, fmt.Errorf("ошибка получения Telegram ID владельца задачи %s: %w", task.ID, err))
+	}
+
+	method, field := j.processor.Delivery()
+	err = w.notifier.SendFile(ownerTGID, method, field, outputPath)
+	if err != nil {
+		return atStage(stageNotify, err)
+	}
+
+	log.Printf("Результат отправлен владельцу задачи %s (Telegram ID: %s).", task.ID, ownerTGID)
+
+	counter := j.processor.Counter()
+	err = w.store.IncrementCounter(task.Owner, counter)
+	if err != nil {
+		// результат уже у владельца, задачу не считаем проваленной
+		log.Printf("Ошибка обновления %s для владельца задачи %s: %v", counter, task.ID, err)
+	}
+
+	return nil
+}
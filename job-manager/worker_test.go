@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"pbclient"
+)
+
+// fakeStore - JobStore в памяти; изменения задач повторяют маршруты pocketbase/pb_hooks
+type fakeStore struct {
+	mu    sync.Mutex
+	tasks map[string]*Task
+	// содержимое файлов задач по имени файла в pocketbase
+	files map[string]string
+	// Telegram ID пользователей по ID записи users
+	tgids map[string]string
+	// ответ на продление аренды
+	heartbeatErr error
+
+	heartbeats int
+	statuses   []string
+	progress   []int
+	fails      []failure
+	released   []string
+	uploaded   map[string]string
+	counters   map[string]int
+}
+
+func newFakeStore(tasks ...*Task) *fakeStore {
+	s := &fakeStore{
+		tasks:    make(map[string]*Task),
+		files:    make(map[string]string),
+		tgids:    make(map[string]string),
+		uploaded: make(map[string]string),
+		counters: make(map[string]int),
+	}
+	for _, task := range tasks {
+		s.tasks[task.ID] = task
+	}
+	return s
+}
+
+func (s *fakeStore) task(taskID string) (*Task, error) {
+	task, ok := s.tasks[taskID]
+	if !ok {
+		return nil, fmt.Errorf("задача %s не найдена", taskID)
+	}
+	return task, nil
+}
+
+// задачи передаются в handle напрямую, без захвата
+func (s *fakeStore) ClaimQueued(collection string) (*Task, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) Heartbeat(collection, taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heartbeats++
+	return s.heartbeatErr
+}
+
+func (s *fakeStore) ReapExpired(collection string) ([]string, []string, error) {
+	return nil, nil, nil
+}
+
+func (s *fakeStore) DownloadFile(ctx context.Context, collection, taskID, fileName, destination string) error {
+	s.mu.Lock()
+	content, ok := s.files[fileName]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("файл %s не найден", fileName)
+	}
+	return os.WriteFile(destination, []byte(content), 0o644)
+}
+
+// как uploadOutputMedia: результат, статус 'sending' и прогресс 100
+func (s *fakeStore) UploadOutput(collection, taskID, filePath string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.task(taskID)
+	if err != nil {
+		return err
+	}
+	s.uploaded[taskID] = string(content)
+	task.Status = "sending"
+	task.Progress = 100
+	return nil
+}
+
+func (s *fakeStore) UpdateStatus(collection, taskID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.task(taskID)
+	if err != nil {
+		return err
+	}
+	s.statuses = append(s.statuses, status)
+	task.Status = status
+	return nil
+}
+
+func (s *fakeStore) UpdateProgress(collection, taskID string, percent int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.task(taskID)
+	if err != nil {
+		return err
+	}
+	s.progress = append(s.progress, percent)
+	task.Progress = percent
+	return nil
+}
+
+func (s *fakeStore) Fail(collection, taskID string, f failure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.task(taskID)
+	if err != nil {
+		return err
+	}
+	s.fails = append(s.fails, f)
+	task.Status = f.status
+	task.ErrorStage = f.stage
+	task.ErrorMessage = f.message
+	if f.status == "queued" {
+		task.NextAttemptAt.Time = f.nextAttempt
+	}
+	return nil
+}
+
+func (s *fakeStore) Get(collection, taskID string) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.task(taskID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *task
+	return &copied, nil
+}
+
+// как маршрут release: задача в очереди, попытка не засчитывается
+func (s *fakeStore) Release(collection, taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, err := s.task(taskID)
+	if err != nil {
+		return err
+	}
+	s.released = append(s.released, taskID)
+	task.Status = "queued"
+	task.Attempts = max(task.Attempts-1, 0)
+	return nil
+}
+
+func (s *fakeStore) OwnerTGID(ownerID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tgid, ok := s.tgids[ownerID]
+	if !ok {
+		return "", fmt.Errorf("владелец %s не найден", ownerID)
+	}
+	return tgid, nil
+}
+
+func (s *fakeStore) IncrementCounter(userID, field string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[userID+"/"+field]++
+	return nil
+}
+
+// fakeNotifier - Notifier, который запоминает отправленное
+type fakeNotifier struct {
+	mu       sync.Mutex
+	files    []sentFile
+	messages []sentMessage
+}
+
+type sentFile struct {
+	chatID, method, field, content string
+}
+
+type sentMessage struct {
+	chatID, text string
+	buttons      []inlineButton
+}
+
+func (n *fakeNotifier) SendFile(chatID, method, field, filePath string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.files = append(n.files, sentFile{chatID, method, field, string(content)})
+	return nil
+}
+
+func (n *fakeNotifier) SendMessage(chatID, text string, buttons []inlineButton) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, sentMessage{chatID, text, buttons})
+	return nil
+}
+
+// fakeProcessor - тип задач без ffmpeg: копирует input_media в результат или возвращает err;
+// с block обработка идет до отмены контекста
+type fakeProcessor struct {
+	err     error
+	block   bool
+	started chan struct{}
+}
+
+func (p *fakeProcessor) Collection() string {
+	return "circle_jobs"
+}
+
+func (p *fakeProcessor) Inputs(task *Task) (map[string]string, error) {
+	return map[string]string{"input_media": task.InputMedia}, nil
+}
+
+func (p *fakeProcessor) Process(ctx context.Context, inputs map[string]string, outputPath string, progress func(percent int)) error {
+	if p.started != nil {
+		close(p.started)
+	}
+	if p.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	progress(50)
+	content, err := os.ReadFile(inputs["input_media"])
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, content, 0o644)
+}
+
+func (p *fakeProcessor) Delivery() (string, string) {
+	return "sendVideoNote", "video_note"
+}
+
+func (p *fakeProcessor) Counter() string {
+	return "circle_count"
+}
+
+// Настройки воркера для тестов: короткая аренда, чтобы heartbeat срабатывал сразу
+func setWorkerConfig(t *testing.T) {
+	t.Helper()
+	lease, attempts, base, maxDelay := leaseDuration, maxAttempts, retryBaseDelay, retryMaxDelay
+	t.Cleanup(func() {
+		leaseDuration, maxAttempts, retryBaseDelay, retryMaxDelay = lease, attempts, base, maxDelay
+	})
+
+	leaseDuration = 30 * time.Millisecond
+	maxAttempts = 3
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay = 30 * time.Minute
+}
+
+func newTestWorker(t *testing.T, processor Processor, store *fakeStore) (*worker, *fakeNotifier) {
+	t.Helper()
+	setWorkerConfig(t)
+
+	notifier := &fakeNotifier{}
+	w := &worker{
+		processors:   []Processor{processor},
+		store:        store,
+		notifier:     notifier,
+		cacheDir:     t.TempDir(),
+		concurrency:  1,
+		processSlots: make(chan struct{}, 1),
+		running:      make(map[string]context.CancelCauseFunc),
+	}
+	return w, notifier
+}
+
+// Задача owner1 в обработке с файлом video.mp4
+func testTask(store *fakeStore) *Task {
+	task := &Task{}
+	task.ID = "job1"
+	task.Owner = "owner1"
+	task.InputMedia = "video.mp4"
+	task.Status = "processing"
+	task.Attempts = 1
+
+	store.tasks[task.ID] = task
+	store.files["video.mp4"] = "video"
+	store.tgids["owner1"] = "1001"
+	return task
+}
+
+// Захваченная задача; в store остается исходная запись
+func claimed(task *Task) *Task {
+	copied := *task
+	return &copied
+}
+
+func TestWorkerHandleCompleted(t *testing.T) {
+	store := newFakeStore()
+	task := testTask(store)
+	w, notifier := newTestWorker(t, &fakeProcessor{}, store)
+
+	w.handle(context.Background(), &job{processor: w.processors[0], task: claimed(task)})
+
+	if task.Status != "completed" || task.Progress != 100 {
+		t.Errorf("задача: status=%s progress=%d, ожидалось completed и 100", task.Status, task.Progress)
+	}
+	if fmt.Sprint(store.statuses) != "[completed]" {
+		t.Errorf("смены статуса: %v", store.statuses)
+	}
+	for _, percent := range store.progress {
+		if percent > 99 {
+			t.Errorf("прогресс %d до загрузки результата", percent)
+		}
+	}
+	if store.uploaded["job1"] != "video" {
+		t.Errorf("загружен результат %q", store.uploaded["job1"])
+	}
+	want := []sentFile{{"1001", "sendVideoNote", "video_note", "video"}}
+	if fmt.Sprint(notifier.files) != fmt.Sprint(want) {
+		t.Errorf("отправлено %v, ожидалось %v", notifier.files, want)
+	}
+	if store.counters["owner1/circle_count"] != 1 {
+		t.Errorf("счетчики %v", store.counters)
+	}
+	if len(store.fails) != 0 || len(notifier.messages) != 0 {
+		t.Errorf("сбои %v, сообщения %v", store.fails, notifier.messages)
+	}
+
+	left, _ := filepath.Glob(filepath.Join(w.cacheDir, "*"))
+	if len(left) != 0 {
+		t.Errorf("в кэше остались файлы %v", left)
+	}
+}
+
+func TestWorkerHandleRetryableError(t *testing.T) {
+	store := newFakeStore()
+	task := testTask(store)
+	w, notifier := newTestWorker(t, &fakeProcessor{err: retryable(errors.New("таймаут"))}, store)
+
+	before := time.Now()
+	w.handle(context.Background(), &job{processor: w.processors[0], task: claimed(task)})
+
+	if task.Status != "queued" {
+		t.Fatalf("status=%s, ожидался queued", task.Status)
+	}
+	if wait := task.NextAttemptAt.Sub(before); wait < retryBaseDelay || wait > retryBaseDelay+time.Minute {
+		t.Errorf("next_attempt_at через %s, ожидалось около %s", wait, retryBaseDelay)
+	}
+	if len(store.statuses) != 0 || len(notifier.files) != 0 {
+		t.Errorf("смены статуса %v, отправлено %v", store.statuses, notifier.files)
+	}
+	// о повторе пользователь не уведомляется
+	if len(notifier.messages) != 0 {
+		t.Errorf("сообщения %v", notifier.messages)
+	}
+}
+
+func TestWorkerHandleFatalError(t *testing.T) {
+	store := newFakeStore()
+	task := testTask(store)
+	w, notifier := newTestWorker(t, &fakeProcessor{err: errors.New("ffmpeg: invalid data")}, store)
+
+	w.handle(context.Background(), &job{processor: w.processors[0], task: claimed(task)})
+
+	if task.Status != "failed" || task.ErrorStage != stageProcess || task.ErrorMessage != "ffmpeg: invalid data" {
+		t.Errorf("задача: status=%s error_stage=%s error_message=%q", task.Status, task.ErrorStage, task.ErrorMessage)
+	}
+	if len(notifier.messages) != 1 {
+		t.Fatalf("сообщений о сбое %d, ожидалось 1", len(notifier.messages))
+	}
+	message := notifier.messages[0]
+	if message.chatID != "1001" || message.text != failureText("job1", store.fails[0]) {
+		t.Errorf("сообщение %+v", message)
+	}
+	if len(message.buttons) != 1 || message.buttons[0].CallbackData != "retry:circle_jobs:job1" {
+		t.Errorf("кнопки %+v", message.buttons)
+	}
+}
+
+func TestWorkerHandleCancelledOnHeartbeat(t *testing.T) {
+	store := newFakeStore()
+	task := testTask(store)
+	// heartbeatTask возвращает errJobCancelled на 410 от pocketbase
+	store.heartbeatErr = errJobCancelled
+	w, notifier := newTestWorker(t, &fakeProcessor{block: true}, store)
+
+	done := make(chan struct{})
+	go func() {
+		w.handle(context.Background(), &job{processor: w.processors[0], task: claimed(task)})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("обработка не прервана после отмены")
+	}
+
+	if store.heartbeats == 0 {
+		t.Error("аренда не продлевалась")
+	}
+	if len(store.fails) != 0 || len(store.statuses) != 0 || len(store.released) != 0 {
+		t.Errorf("сбои %v, смены статуса %v, возвраты %v", store.fails, store.statuses, store.released)
+	}
+	if len(notifier.messages) != 0 || len(notifier.files) != 0 {
+		t.Errorf("сообщения %v, отправлено %v", notifier.messages, notifier.files)
+	}
+}
+
+func TestWorkerHandleShutdown(t *testing.T) {
+	store := newFakeStore()
+	task := testTask(store)
+	processor := &fakeProcessor{block: true, started: make(chan struct{})}
+	w, notifier := newTestWorker(t, processor, store)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.handle(ctx, &job{processor: processor, task: claimed(task)})
+		close(done)
+	}()
+
+	<-processor.started
+	cancel(errShutdown)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("обработка не прервана при остановке")
+	}
+
+	if fmt.Sprint(store.released) != "[job1]" {
+		t.Errorf("возвращены в очередь %v", store.released)
+	}
+	if task.Status != "queued" || task.Attempts != 0 {
+		t.Errorf("задача: status=%s attempts=%d, ожидалось queued и 0", task.Status, task.Attempts)
+	}
+	if len(store.fails) != 0 || len(store.statuses) != 0 || len(notifier.messages) != 0 {
+		t.Errorf("сбои %v, смены статуса %v, сообщения %v", store.fails, store.statuses, notifier.messages)
+	}
+}
+
+// Ответы маршрута heartbeat: 410 - задача отменена, 409 - аренда у другого воркера
+func TestHeartbeatTaskStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusOK, nil},
+		{http.StatusGone, errJobCancelled},
+		{http.StatusConflict, errLeaseLost},
+		{http.StatusNotFound, errLeaseLost},
+	}
+
+	setWorkerConfig(t)
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.URL.Path != "/api/jobs/circle_jobs/job1/heartbeat" {
+				t.Errorf("запрос %s %s", r.Method, r.URL.Path)
+			}
+			writeJSON(w, tt.status, map[string]interface{}{"code": tt.status, "message": "", "data": map[string]interface{}{}})
+		}))
+		pb = pbclient.New(server.URL, "admin@example.com", "password")
+
+		err := heartbeatTask(pbclient.CircleJobsCollection, "job1", "test-worker")
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("ответ %d: ошибка %v, ожидалась %v", tt.status, err, tt.want)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,327 @@
+// Package pbclient - клиент REST API pocketbase, общий для telegram-bot и job-manager
+package pbclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// токен обновляется заранее, если до истечения осталось меньше tokenRefreshMargin
+const tokenRefreshMargin = 5 * time.Minute
+
+// попытки повторной авторизации после 401/403: паузы 1s, 2s, 4s, ...
+const reauthAttempts = 5
+
+// Client - клиент pocketbase с авторизацией администратора
+type Client struct {
+	// адрес pocketbase без завершающего "/"
+	URL string
+	// HTTP-клиент для запросов, в тестах можно подменить на клиент httptest
+	HTTPClient *http.Client
+
+	email    string
+	password string
+
+	// JWT администратора и время его истечения; authMu держится только для чтения и замены токена
+	authMu      sync.Mutex
+	authToken   string
+	authExpires time.Time
+	// текущий запрос нового токена: одновременные вызовы ждут его, а не отправляют свои
+	authCall *authCall
+}
+
+// authCall - запрос нового токена, общий для одновременных вызовов
+type authCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// Новый клиент; токен запрашивается в Authenticate
+func New(url, email, password string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(url, "/"),
+		HTTPClient: &http.Client{},
+		email:      email,
+		password:   password,
+	}
+}
+
+// getting JWT for pocketbase
+func (c *Client) Authenticate() error {
+	_, err := c.renewToken("", c.requestAdminToken)
+	if err != nil {
+		return err
+	}
+
+	log.Println("PocketBase: Авторизация прошла успешно. Получен токен от PocketBase.")
+	return nil
+}
+
+// Текущий токен; за tokenRefreshMargin до истечения он обновляется.
+// Пока токен действует, вызов не ждет чужого обновления и возвращает текущий.
+func (c *Client) Token() string {
+	c.authMu.Lock()
+	token, expires := c.authToken, c.authExpires
+	renewing := c.authCall != nil
+	c.authMu.Unlock()
+
+	if token == "" || expires.IsZero() || time.Until(expires) >= tokenRefreshMargin {
+		return token
+	}
+	if renewing && time.Now().Before(expires) {
+		return token
+	}
+
+	refreshed, err := c.renewToken(token, func() (string, error) {
+		refreshed, err := c.refreshAdminToken(token)
+		if err != nil {
+			log.Printf("PocketBase: не удалось обновить токен: %v, повторная авторизация", err)
+			refreshed, err = c.requestAdminToken()
+		}
+		return refreshed, err
+	})
+	if err != nil {
+		log.Printf("PocketBase: ошибка повторной авторизации: %v", err)
+		return token
+	}
+	return refreshed
+}
+
+// Повторная авторизация после отказа pocketbase с токеном usedToken.
+// Если токен уже заменил другой запрос, новый не запрашивается.
+func (c *Client) reauthenticate(usedToken string) error {
+	_, err := c.renewToken(usedToken, func() (string, error) {
+		var err error
+		delay := time.Second
+		for attempt := 1; attempt <= reauthAttempts; attempt++ {
+			var token string
+			token, err = c.requestAdminToken()
+			if err == nil {
+				log.Println("PocketBase: токен отклонен, выполнена повторная авторизация.")
+				return token, nil
+			}
+
+			log.Printf("PocketBase: попытка авторизации %d из %d не удалась: %v", attempt, reauthAttempts, err)
+			if attempt < reauthAttempts {
+				time.Sleep(delay)
+				delay *= 2
+			}
+		}
+		return "", err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: повторная авторизация не удалась: %w", ErrAuth, err)
+	}
+	return nil
+}
+
+// Новый токен от fetch на замену current ("" - замена в любом случае); если current уже заменен,
+// возвращается действующий токен. Сетевой запрос и паузы между попытками идут без authMu,
+// поэтому запросы с действующим токеном не ждут авторизацию; одновременные вызовы
+// получают результат одного fetch.
+func (c *Client) renewToken(current string, fetch func() (string, error)) (string, error) {
+	c.authMu.Lock()
+	if current != "" && c.authToken != current {
+		token := c.authToken
+		c.authMu.Unlock()
+		return token, nil
+	}
+	if call := c.authCall; call != nil {
+		c.authMu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &authCall{done: make(chan struct{})}
+	c.authCall = call
+	c.authMu.Unlock()
+
+	call.token, call.err = fetch()
+
+	c.authMu.Lock()
+	if call.err == nil {
+		c.setAuthToken(call.token)
+	}
+	c.authCall = nil
+	c.authMu.Unlock()
+	close(call.done)
+
+	return call.token, call.err
+}
+
+// Запрос к pocketbase с токеном администратора.
+// newRequest вызывается повторно, если после 401/403 запрос нужно отправить с новым токеном.
+// Код ответа не проверяется, тело закрывает вызывающий.
+func (c *Client) Do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		token := c.Token()
+		if token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if attempt == 0 && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			resp.Body.Close()
+			err = c.reauthenticate(token)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return resp, nil
+	}
+}
+
+// JSON-запрос к pocketbase по пути path ("/api/...").
+// Ответ с кодом не из 2xx возвращается как *Error, иначе тело разбирается в out, если он не nil.
+func (c *Client) Send(method, path string, payload, out interface{}) error {
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("ошибка сериализации данных: %v", err)
+		}
+	}
+
+	resp, err := c.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания запроса: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readResponse(resp, out)
+}
+
+// Проверка кода ответа и разбор тела в out
+func readResponse(resp *http.Response, out interface{}) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения ответа: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newError(resp.StatusCode, body)
+	}
+
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("ошибка разбора ответа: %v, ответ: %s", err, string(body))
+	}
+	return nil
+}
+
+// must be called with authMu held
+func (c *Client) setAuthToken(token string) {
+	c.authToken = token
+	c.authExpires = tokenExpiry(token)
+}
+
+// Время истечения из поля exp JWT; нулевое, если его не удалось прочитать
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
+
+// Новый токен по логину и паролю администратора
+func (c *Client) requestAdminToken() (string, error) {
+	authData := map[string]string{
+		"identity": c.email,
+		"password": c.password,
+	}
+
+	authDataJson, _ := json.Marshal(authData)
+
+	authURL := fmt.Sprintf("%s/api/admins/auth-with-password", c.URL)
+
+	resp, err := c.HTTPClient.Post(authURL, "application/json", bytes.NewBuffer(authDataJson))
+	if err != nil {
+		return "", fmt.Errorf("не удалось отправить запрос на авторизацию: %v", err)
+	}
+	defer resp.Body.Close()
+
+	return readAuthResponse(resp)
+}
+
+// Продление действующего токена через /api/admins/auth-refresh
+func (c *Client) refreshAdminToken(token string) (string, error) {
+	refreshURL := fmt.Sprintf("%s/api/admins/auth-refresh", c.URL)
+
+	req, err := http.NewRequest("POST", refreshURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания запроса: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("не удалось отправить запрос на обновление токена: %v", err)
+	}
+	defer resp.Body.Close()
+
+	return readAuthResponse(resp)
+}
+
+func readAuthResponse(resp *http.Response) (string, error) {
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("авторизация не удалась: %w", newError(resp.StatusCode, body))
+	}
+
+	// getting jwt
+	var authResponse struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &authResponse); err != nil {
+		return "", fmt.Errorf("ошибка разбора ответа: %v, ответ: %s", err, string(body))
+	}
+
+	if authResponse.Token == "" {
+		return "", fmt.Errorf("не удалось получить токен из ответа: %s", string(body))
+	}
+
+	return authResponse.Token, nil
+}
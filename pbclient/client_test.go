@@ -0,0 +1,582 @@
+package pbclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// JWT с полем exp; подпись не проверяется
+func testToken(name string, expires time.Time) string {
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	return encode(map[string]string{"alg": "HS256"}) + "." +
+		encode(map[string]interface{}{"name": name, "exp": expires.Unix()}) + ".sig"
+}
+
+// authServer - авторизация администратора pocketbase: каждый вход выдает токен из logins по порядку
+type authServer struct {
+	mu       sync.Mutex
+	logins   []string
+	refresh  string // токен из /api/admins/auth-refresh; пусто - отказ
+	calls    map[string]int
+	mux      *http.ServeMux
+	lastAuth string
+	// если задан, вход и обновление токена ждут его закрытия
+	gate chan struct{}
+}
+
+func newAuthServer(logins ...string) *authServer {
+	s := &authServer{logins: logins, calls: make(map[string]int), mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /api/admins/auth-with-password", func(w http.ResponseWriter, r *http.Request) {
+		s.wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls["login"]++
+
+		var data map[string]string
+		json.NewDecoder(r.Body).Decode(&data)
+		if data["identity"] != "admin@example.com" || data["password"] != "password" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":400,"message":"Failed to authenticate.","data":{}}`)
+			return
+		}
+		token := s.logins[min(s.calls["login"], len(s.logins))-1]
+		fmt.Fprintf(w, `{"token":%q,"admin":{"id":"a1"}}`, token)
+	})
+	s.mux.HandleFunc("POST /api/admins/auth-refresh", func(w http.ResponseWriter, r *http.Request) {
+		s.wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls["refresh"]++
+
+		if s.refresh == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":401,"message":"The request requires valid admin authorization token to be set.","data":{}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, s.refresh)
+	})
+	return s
+}
+
+func (s *authServer) wait() {
+	s.mu.Lock()
+	gate := s.gate
+	s.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+}
+
+func (s *authServer) setGate(gate chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gate = gate
+}
+
+func (s *authServer) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[name]
+}
+
+func (s *authServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.lastAuth = r.Header.Get("Authorization")
+	s.mu.Unlock()
+	s.mux.ServeHTTP(w, r)
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := New(server.URL+"/", "admin@example.com", "password")
+	client.HTTPClient = server.Client()
+	return client
+}
+
+func TestDoReauthenticatesAndReplaysMultipart(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			expired := testToken("expired", time.Now().Add(time.Hour))
+			fresh := testToken("fresh", time.Now().Add(time.Hour))
+			auth := newAuthServer(expired, fresh)
+
+			var (
+				attempts int
+				bodies   []string
+			)
+			auth.mux.HandleFunc("POST /api/collections/circle_jobs/records", func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if err := r.ParseMultipartForm(1 << 20); err != nil {
+					t.Errorf("попытка %d: multipart не разобран: %v", attempts, err)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				file, _, err := r.FormFile("input_media")
+				if err != nil {
+					t.Errorf("попытка %d: нет файла: %v", attempts, err)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				content, _ := io.ReadAll(file)
+				bodies = append(bodies, r.FormValue("status")+"/"+string(content))
+
+				if r.Header.Get("Authorization") != "Bearer "+fresh {
+					w.WriteHeader(status)
+					fmt.Fprint(w, `{"code":401,"message":"The request requires valid admin authorization token to be set.","data":{}}`)
+					return
+				}
+				fmt.Fprint(w, `{"id":"job1","status":"pending"}`)
+			})
+
+			client := newTestClient(t, auth)
+			if err := client.Authenticate(); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, []byte("video data"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			var job Job
+			err := client.CreateWithFiles(CircleJobsCollection, map[string]string{"status": "pending"}, []File{{Field: "input_media", Path: path}}, &job)
+			if err != nil {
+				t.Fatalf("CreateWithFiles: %v", err)
+			}
+			if job.ID != "job1" {
+				t.Errorf("ID = %q", job.ID)
+			}
+			if attempts != 2 || auth.count("login") != 2 {
+				t.Errorf("запросов %d, входов %d; ожидалось 2 и 2", attempts, auth.count("login"))
+			}
+			// повтор отправляет то же тело целиком
+			for i, body := range bodies {
+				if body != "pending/video data" {
+					t.Errorf("тело попытки %d: %q", i+1, body)
+				}
+			}
+			if client.Token() != fresh {
+				t.Error("клиент не сохранил новый токен")
+			}
+		})
+	}
+}
+
+// Тело multipart не хранится в памяти: запрос идет без Content-Length, а повтор после
+// повторной авторизации заново читает файл с диска
+func TestSendMultipartStreamsFromDisk(t *testing.T) {
+	expired := testToken("expired", time.Now().Add(time.Hour))
+	fresh := testToken("fresh", time.Now().Add(time.Hour))
+	auth := newAuthServer(expired, fresh)
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var bodies []string
+	auth.mux.HandleFunc("PATCH /api/collections/circle_jobs/records/job1", func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength != -1 {
+			t.Errorf("Content-Length = %d, тело собрано заранее", r.ContentLength)
+		}
+		file, _, err := r.FormFile("output_media")
+		if err != nil {
+			t.Errorf("нет файла: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		bodies = append(bodies, string(content))
+
+		if r.Header.Get("Authorization") != "Bearer "+fresh {
+			// файл меняется до повтора: повтор должен отправить новое содержимое
+			os.WriteFile(path, []byte("second"), 0o600)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":401,"message":"","data":{}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"job1"}`)
+	})
+
+	client := newTestClient(t, auth)
+	if err := client.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.UpdateWithFiles(CircleJobsCollection, "job1", nil, []File{{Field: "output_media", Path: path}}, nil)
+	if err != nil {
+		t.Fatalf("UpdateWithFiles: %v", err)
+	}
+	if fmt.Sprint(bodies) != "[first second]" {
+		t.Errorf("отправлено %v, ожидалось [first second]", bodies)
+	}
+}
+
+func TestSendMultipartErrors(t *testing.T) {
+	requests := 0
+	auth := newAuthServer(testToken("admin", time.Now().Add(time.Hour)))
+	// ответ до чтения тела: отправка большого файла прерывается, а не зависает
+	auth.mux.HandleFunc("POST /api/collections/circle_jobs/records", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprint(w, `{"code":413,"message":"Request entity too large.","data":{}}`)
+	})
+	client := newTestClient(t, auth)
+
+	err := client.CreateWithFiles(CircleJobsCollection, nil, []File{{Field: "input_media", Path: filepath.Join(t.TempDir(), "missing.mp4")}}, nil)
+	if err == nil || requests != 0 {
+		t.Errorf("отсутствующий файл: err=%v, запросов %d", err, requests)
+	}
+
+	path := filepath.Join(t.TempDir(), "large.mp4")
+	if err := os.WriteFile(path, make([]byte, 8<<20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.CreateWithFiles(CircleJobsCollection, nil, []File{{Field: "input_media", Path: path}}, nil)
+	}()
+	select {
+	case err := <-done:
+		// сервер может закрыть соединение раньше, чем клиент прочитает ответ
+		if err == nil {
+			t.Error("ожидалась ошибка")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("отправка не прервана после ответа сервера")
+	}
+}
+
+func TestDoRetriesOnlyOnce(t *testing.T) {
+	auth := newAuthServer(testToken("first", time.Now().Add(time.Hour)), testToken("second", time.Now().Add(time.Hour)))
+	attempts := 0
+	auth.mux.HandleFunc("GET /api/collections/users/records/u1", func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"code":403,"message":"Only admins can perform this action.","data":{}}`)
+	})
+
+	client := newTestClient(t, auth)
+	if err := client.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Get[User](client, UsersCollection, "u1")
+	if !IsAuth(err) {
+		t.Fatalf("ожидалась ошибка авторизации, получено %v", err)
+	}
+	if attempts != 2 || auth.count("login") != 2 {
+		t.Errorf("запросов %d, входов %d; ожидалось 2 и 2", attempts, auth.count("login"))
+	}
+}
+
+func TestTokenRefreshBeforeExpiry(t *testing.T) {
+	expiring := testToken("expiring", time.Now().Add(tokenRefreshMargin/2))
+	refreshed := testToken("refreshed", time.Now().Add(time.Hour))
+	auth := newAuthServer(expiring)
+	auth.refresh = refreshed
+
+	client := newTestClient(t, auth)
+	if err := client.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := client.Token(); got != refreshed {
+		t.Fatalf("Token() не обновил токен перед истечением")
+	}
+	// refresh отправляется со старым токеном
+	if auth.lastAuth != "Bearer "+expiring {
+		t.Errorf("auth-refresh с заголовком %q", auth.lastAuth)
+	}
+	// обновленный токен действует долго, повторного обновления нет
+	client.Token()
+	if auth.count("refresh") != 1 || auth.count("login") != 1 {
+		t.Errorf("обновлений %d, входов %d; ожидалось 1 и 1", auth.count("refresh"), auth.count("login"))
+	}
+}
+
+func TestTokenRefreshFallsBackToLogin(t *testing.T) {
+	expiring := testToken("expiring", time.Now().Add(time.Minute))
+	relogin := testToken("relogin", time.Now().Add(time.Hour))
+	auth := newAuthServer(expiring, relogin)
+
+	client := newTestClient(t, auth)
+	if err := client.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := client.Token(); got != relogin {
+		t.Fatal("после отказа auth-refresh токен не получен заново")
+	}
+	if auth.count("refresh") != 1 || auth.count("login") != 2 {
+		t.Errorf("обновлений %d, входов %d; ожидалось 1 и 2", auth.count("refresh"), auth.count("login"))
+	}
+}
+
+// Ожидание, пока клиент запрашивает новый токен
+func waitRenewing(t *testing.T, client *Client) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.authMu.Lock()
+		renewing := client.authCall != nil
+		client.authMu.Unlock()
+		if renewing {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("клиент не начал авторизацию")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Token() с действующим токеном не ждет, пока повторная авторизация ходит в сеть,
+// а одновременные повторные авторизации выполняют один вход
+func TestReauthenticateDoesNotBlockToken(t *testing.T) {
+	first := testToken("first", time.Now().Add(time.Hour))
+	second := testToken("second", time.Now().Add(time.Hour))
+	auth := newAuthServer(first, second)
+	client := newTestClient(t, auth)
+	if err := client.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	auth.setGate(gate)
+
+	const callers = 10
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() { errs <- client.reauthenticate(first) }()
+	}
+	waitRenewing(t, client)
+
+	got := make(chan string, 1)
+	go func() { got <- client.Token() }()
+	select {
+	case token := <-got:
+		if token != first {
+			t.Errorf("Token() во время авторизации вернул другой токен")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Token() ждет повторную авторизацию")
+	}
+
+	close(gate)
+	for i := 0; i < callers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("reauthenticate: %v", err)
+		}
+	}
+	if client.Token() != second {
+		t.Error("новый токен не сохранен")
+	}
+	if auth.count("login") != 2 {
+		t.Errorf("входов %d, ожидалось 2", auth.count("login"))
+	}
+}
+
+// Пока один вызов обновляет истекающий токен, остальные получают текущий без ожидания
+func TestTokenRefreshSingleFlight(t *testing.T) {
+	expiring := testToken("expiring", time.Now().Add(tokenRefreshMargin/2))
+	refreshed := testToken("refreshed", time.Now().Add(time.Hour))
+	auth := newAuthServer(expiring)
+	auth.refresh = refreshed
+	client := newTestClient(t, auth)
+	if err := client.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := make(chan struct{})
+	auth.setGate(gate)
+	leader := make(chan string, 1)
+	go func() { leader <- client.Token() }()
+	waitRenewing(t, client)
+
+	for i := 0; i < 10; i++ {
+		if client.Token() != expiring {
+			t.Fatal("Token() во время обновления вернул другой токен")
+		}
+	}
+
+	close(gate)
+	if <-leader != refreshed || client.Token() != refreshed {
+		t.Error("токен не обновлен")
+	}
+	if auth.count("refresh") != 1 || auth.count("login") != 1 {
+		t.Errorf("обновлений %d, входов %d; ожидалось 1 и 1", auth.count("refresh"), auth.count("login"))
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	if got := tokenExpiry(testToken("a", expires)); !got.Equal(expires) {
+		t.Errorf("tokenExpiry = %v, ожидалось %v", got, expires)
+	}
+	for _, token := range []string{"", "not-a-jwt", "a.b.c", "a." + base64.RawURLEncoding.EncodeToString([]byte(`{}`)) + ".c"} {
+		if got := tokenExpiry(token); !got.IsZero() {
+			t.Errorf("tokenExpiry(%q) = %v, ожидалось нулевое время", token, got)
+		}
+	}
+}
+
+func TestListAllStopsAtTotalPages(t *testing.T) {
+	const total, perPage = 7, 3
+	var pages []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/collections/circle_jobs/records", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pages = append(pages, query.Get("page"))
+		if query.Get("perPage") != strconv.Itoa(perPage) || query.Get("sort") != "created" {
+			t.Errorf("параметры запроса: %s", r.URL.RawQuery)
+		}
+
+		page, _ := strconv.Atoi(query.Get("page"))
+		totalPages := (total + perPage - 1) / perPage
+		result := ListResult[Job]{Page: page, PerPage: perPage, TotalItems: total, TotalPages: totalPages}
+		for i := (page - 1) * perPage; i < min(page*perPage, total); i++ {
+			job := Job{}
+			job.ID = fmt.Sprintf("job%d", i)
+			result.Items = append(result.Items, job)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+
+	client := newTestClient(t, mux)
+	jobs, err := ListAll[Job](client, CircleJobsCollection, ListQuery{Sort: "created", PerPage: perPage})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(pages) != "[1 2 3]" {
+		t.Errorf("запрошены страницы %v, ожидались [1 2 3]", pages)
+	}
+	if len(jobs) != total || jobs[0].ID != "job0" || jobs[total-1].ID != "job6" {
+		t.Errorf("получено %d записей: %v", len(jobs), jobs)
+	}
+}
+
+func TestListAllEmpty(t *testing.T) {
+	requests := 0
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("perPage") != strconv.Itoa(maxPerPage) {
+			t.Errorf("perPage по умолчанию: %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `{"page":1,"perPage":500,"totalItems":0,"totalPages":0,"items":[]}`)
+	}))
+
+	jobs, err := ListAll[Job](client, CircleJobsCollection, ListQuery{})
+	if err != nil || len(jobs) != 0 || requests != 1 {
+		t.Errorf("jobs=%v err=%v запросов=%d", jobs, err, requests)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		code    int
+		message string
+		data    map[string]FieldError
+		text    string
+		is      error
+	}{
+		{
+			name:    "ошибки полей",
+			status:  400,
+			body:    `{"code":400,"message":"Failed to create record.","data":{"tgid":{"code":"validation_not_unique","message":"Value must be unique."},"username":{"code":"validation_required","message":"Missing required value."}}}`,
+			code:    400,
+			message: "Failed to create record.",
+			data: map[string]FieldError{
+				"tgid":     {Code: "validation_not_unique", Message: "Value must be unique."},
+				"username": {Code: "validation_required", Message: "Missing required value."},
+			},
+			text: "pocketbase: статус 400: Failed to create record. (tgid: Value must be unique.; username: Missing required value.)",
+			is:   ErrValidation,
+		},
+		{
+			name:    "вложенные ошибки json-поля",
+			status:  400,
+			body:    `{"code":400,"message":"Failed to update record.","data":{"data":{"face_file_id":{"code":"validation_required","message":"Missing required value."}}}}`,
+			code:    400,
+			message: "Failed to update record.",
+			data: map[string]FieldError{
+				"data": {Message: `{"face_file_id":{"code":"validation_required","message":"Missing required value."}}`},
+			},
+			text: `pocketbase: статус 400: Failed to update record. (data: {"face_file_id":{"code":"validation_required","message":"Missing required value."}})`,
+			is:   ErrValidation,
+		},
+		{
+			name:    "без данных",
+			status:  404,
+			body:    `{"code":404,"message":"The requested resource wasn't found.","data":{}}`,
+			code:    404,
+			message: "The requested resource wasn't found.",
+			text:    "pocketbase: статус 404: The requested resource wasn't found.",
+			is:      ErrNotFound,
+		},
+		{
+			name:   "не json",
+			status: 403,
+			body:   "<html>Forbidden</html>",
+			text:   "pocketbase: статус 403, ответ: <html>Forbidden</html>",
+			is:     ErrAuth,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newError(tt.status, []byte(tt.body))
+			if e.Status != tt.status || e.Body != tt.body || e.Code != tt.code || e.Message != tt.message {
+				t.Errorf("Error = %+v", e)
+			}
+			if fmt.Sprint(e.Data) != fmt.Sprint(tt.data) {
+				t.Errorf("Data = %v, ожидалось %v", e.Data, tt.data)
+			}
+			if e.Error() != tt.text {
+				t.Errorf("Error() = %s\nожидалось %s", e.Error(), tt.text)
+			}
+
+			wrapped := fmt.Errorf("запрос: %w", e)
+			if !errors.Is(wrapped, tt.is) || StatusCode(wrapped) != tt.status {
+				t.Errorf("errors.Is(%v) или StatusCode не сработали для %v", tt.is, wrapped)
+			}
+			if fmt.Sprint(FieldErrors(wrapped)) != fmt.Sprint(tt.data) {
+				t.Errorf("FieldErrors = %v", FieldErrors(wrapped))
+			}
+		})
+	}
+}
+
+// Ответ с ошибкой через Send возвращается как *Error
+func TestSendDecodesError(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":400,"message":"Failed to create record.","data":{"tgid":{"code":"validation_not_unique","message":"Value must be unique."}}}`)
+	}))
+
+	err := client.Create(UsersCollection, map[string]interface{}{"tgid": 1}, nil)
+	if !IsValidation(err) || FieldErrors(err)["tgid"].Code != "validation_not_unique" {
+		t.Fatalf("ошибка %v", err)
+	}
+}
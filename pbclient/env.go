@@ -0,0 +1,83 @@
+package pbclient
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+)
+
+// Environment - настройки, общие для telegram-bot и job-manager
+type Environment struct {
+	BotToken    string
+	BotDebug    bool
+	BotEndpoint string
+
+	PocketBaseURL      string
+	PocketBaseLogin    string
+	PocketBasePassword string
+}
+
+// loading env variables from .env or system environment
+func LoadEnvironment() Environment {
+	if os.Getenv("DOCKER_BUILD") == `` {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatalf("Error loading .env file")
+		}
+	}
+
+	var env Environment
+
+	// load telegram token
+	env.BotToken = os.Getenv("TELEGRAM_APITOKEN")
+	if env.BotToken == `` {
+		log.Fatal("empty telegram api token loaded, check TELEGRAM_APITOKEN value")
+	}
+
+	// telegram bot debug mode
+	env.BotDebug = os.Getenv("BOT_DEBUG") == `true`
+
+	env.BotEndpoint = os.Getenv("TELEGRAM_API")
+	if env.BotEndpoint == `` {
+		env.BotEndpoint = "https://api.telegram.org"
+	}
+
+	// pocketbase
+	env.PocketBaseURL = os.Getenv("POCKETBASE_URL")
+	if env.PocketBaseURL == `` {
+		log.Fatal("empty pocketbase url loaded, check POCKETBASE_URL value")
+	}
+
+	env.PocketBaseLogin = os.Getenv("POCKETBASE_LOGIN")
+	if env.PocketBaseLogin == `` {
+		log.Fatal("empty pocketbase login loaded. env is not correct or configuration is insecure")
+	}
+
+	env.PocketBasePassword = os.Getenv("POCKETBASE_PASSWORD")
+	if env.PocketBasePassword == `` {
+		log.Fatal("empty pocketbase password loaded. env is not correct or configuration is insecure")
+	}
+
+	return env
+}
+
+// Клиент pocketbase с адресом и учетными данными из окружения
+func (env Environment) PocketBase() *Client {
+	return New(env.PocketBaseURL, env.PocketBaseLogin, env.PocketBasePassword)
+}
+
+// Положительное целое из переменной окружения name; fallback, если она не задана
+func IntEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == `` {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Fatalf("incorrect %s value: %q", name, value)
+	}
+	return parsed
+}
@@ -0,0 +1,138 @@
+package pbclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Классы ошибок pocketbase для errors.Is
+var (
+	// запись не найдена: ответ 404 или пустой результат поиска
+	ErrNotFound = errors.New("запись не найдена")
+	// данные не прошли проверку: ответ 400
+	ErrValidation = errors.New("ошибка валидации")
+	// токен отклонен или у администратора нет прав: ответ 401/403
+	ErrAuth = errors.New("ошибка авторизации")
+)
+
+// FieldError - ошибка проверки одного поля записи
+type FieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error - ответ pocketbase с кодом не из 2xx.
+// Поля Code, Message и Data разбираются из {code, message, data}, Body - тело ответа как есть.
+type Error struct {
+	Status  int
+	Code    int
+	Message string
+	Data    map[string]FieldError
+	Body    string
+}
+
+// Разбор ответа pocketbase с ошибкой; если тело не в формате pocketbase, заполняются только Status и Body
+func newError(status int, body []byte) *Error {
+	e := &Error{Status: status, Body: string(body)}
+
+	var envelope struct {
+		Code    int                        `json:"code"`
+		Message string                     `json:"message"`
+		Data    map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return e
+	}
+
+	e.Code = envelope.Code
+	e.Message = envelope.Message
+	for field, raw := range envelope.Data {
+		var fieldErr FieldError
+		// вложенные ошибки (например, для json полей) сохраняются без кода
+		if err := json.Unmarshal(raw, &fieldErr); err != nil || fieldErr.Message == "" {
+			fieldErr = FieldError{Message: string(raw)}
+		}
+		if e.Data == nil {
+			e.Data = make(map[string]FieldError)
+		}
+		e.Data[field] = fieldErr
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("pocketbase: статус %d, ответ: %s", e.Status, e.Body)
+	}
+
+	message := fmt.Sprintf("pocketbase: статус %d: %s", e.Status, e.Message)
+	if fields := e.Fields(); fields != "" {
+		message += " (" + fields + ")"
+	}
+	return message
+}
+
+// Ошибки полей одной строкой: "field: message; ..."
+func (e *Error) Fields() string {
+	names := make([]string, 0, len(e.Data))
+	for name := range e.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, e.Data[name].Message))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// errors.Is сопоставляет код ответа с ErrNotFound, ErrValidation и ErrAuth
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrValidation:
+		return e.Status == http.StatusBadRequest
+	case ErrAuth:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	}
+	return false
+}
+
+// Запись не найдена
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// Pocketbase отклонил данные запроса
+func IsValidation(err error) bool {
+	return errors.Is(err, ErrValidation)
+}
+
+// Pocketbase отклонил токен администратора
+func IsAuth(err error) bool {
+	return errors.Is(err, ErrAuth)
+}
+
+// Ошибки полей из цепочки err; nil, если это не ошибка валидации pocketbase
+func FieldErrors(err error) map[string]FieldError {
+	var pbErr *Error
+	if errors.As(err, &pbErr) {
+		return pbErr.Data
+	}
+	return nil
+}
+
+// Код ответа pocketbase из цепочки err; 0, если ошибка не от pocketbase
+func StatusCode(err error) int {
+	var pbErr *Error
+	if errors.As(err, &pbErr) {
+		return pbErr.Status
+	}
+	return 0
+}
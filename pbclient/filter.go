@@ -0,0 +1,85 @@
+package pbclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Params - значения для плейсхолдеров {:name} в фильтре
+type Params map[string]interface{}
+
+var placeholderPattern = regexp.MustCompile(`\{:(\w+)\}`)
+
+// Фильтр pocketbase с подставленными параметрами, как pb.filter() в JS SDK:
+//
+//	Filter("owner={:owner} && status!={:status}", Params{"owner": id, "status": "completed"})
+//
+// Строки берутся в одинарные кавычки, кавычки внутри экранируются. Плейсхолдеры заменяются
+// за один проход, поэтому "{:...}" внутри значений остается текстом. URL-кодирование выполняет ListQuery.
+func Filter(expr string, params Params) (string, error) {
+	var err error
+	filter := placeholderPattern.ReplaceAllStringFunc(expr, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		value, ok := params[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("параметр %s фильтра не задан", name)
+			}
+			return placeholder
+		}
+
+		literal, quoteErr := quoteValue(value)
+		if quoteErr != nil && err == nil {
+			err = fmt.Errorf("параметр %s фильтра: %w", name, quoteErr)
+		}
+		return literal
+	})
+	if err != nil {
+		return "", err
+	}
+	return filter, nil
+}
+
+// Литерал фильтра для значения параметра
+func quoteValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		return quoteString(v)
+	case DateTime:
+		return quoteString(v.String())
+	case time.Time:
+		if v.IsZero() {
+			return quoteString("")
+		}
+		return quoteString(NewDateTime(v).String())
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("ошибка сериализации значения: %v", err)
+	}
+	return quoteString(string(data))
+}
+
+// Строка в одинарных кавычках. Парсер фильтров pocketbase не умеет экранировать "\",
+// поэтому "\" в конце строки экранировал бы закрывающую кавычку - такие значения отклоняются.
+func quoteString(value string) (string, error) {
+	if strings.HasSuffix(value, `\`) {
+		return "", fmt.Errorf("значение %q оканчивается на \\ и не может быть записано в фильтр", value)
+	}
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'", nil
+}
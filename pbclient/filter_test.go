@@ -0,0 +1,121 @@
+package pbclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Разбор строкового литерала так же, как сканер фильтров pocketbase (fexpr):
+// литерал заканчивается на кавычке, перед которой нет "\", затем `\'` заменяется на `'`
+func unquoteFilterString(t *testing.T, literal string) string {
+	t.Helper()
+	if len(literal) < 2 || literal[0] != '\'' {
+		t.Fatalf("литерал %s не в одинарных кавычках", literal)
+	}
+
+	var prev rune
+	end := -1
+	for i, ch := range literal[1:] {
+		if ch == '\'' && prev != '\\' {
+			end = i + 1
+			break
+		}
+		prev = ch
+	}
+	if end != len(literal)-1 {
+		t.Fatalf("литерал %s закрывается не последней кавычкой", literal)
+	}
+	return strings.ReplaceAll(literal[1:end], `\'`, `'`)
+}
+
+func TestFilter(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	tests := []struct {
+		name   string
+		expr   string
+		params Params
+		want   string
+	}{
+		{"кавычка", "name={:v}", Params{"v": "it's"}, `name='it\'s'`},
+		{"экранированная кавычка", "name={:v}", Params{"v": `a\'b`}, `name='a\\'b'`},
+		{"обратный слеш внутри", "name={:v}", Params{"v": `C:\dir\file`}, `name='C:\dir\file'`},
+		{"двойные кавычки и операторы", "name={:v}", Params{"v": `" || id!="`}, `name='" || id!="'`},
+		{"плейсхолдер в значении", "a={:a} && b={:b}", Params{"a": "{:b}", "b": "x"}, `a='{:b}' && b='x'`},
+		{"повтор параметра", "a={:v} || b={:v}", Params{"v": 1}, `a=1 || b=1`},
+		{"числа и bool", "n={:n} && f={:f} && ok={:ok}", Params{"n": int64(-5), "f": 1.5, "ok": true}, `n=-5 && f=1.5 && ok=true`},
+		{"nil", "parent={:p}", Params{"p": nil}, `parent=null`},
+		{"DateTime", "d<={:d}", Params{"d": NewDateTime(time.Date(2024, 5, 1, 12, 30, 0, 0, moscow))}, `d<='2024-05-01 09:30:00.000Z'`},
+		{"пустой DateTime", "d={:d}", Params{"d": DateTime{}}, `d=''`},
+		{"time.Time в UTC", "d<={:d}", Params{"d": time.Date(2024, 5, 1, 12, 30, 0, 123e6, moscow)}, `d<='2024-05-01 09:30:00.123Z'`},
+		{"нулевой time.Time", "d={:d}", Params{"d": time.Time{}}, `d=''`},
+		{"без параметров", "status='queued'", nil, `status='queued'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Filter(tt.expr, tt.params)
+			if err != nil {
+				t.Fatalf("Filter: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Filter = %s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Строки после разбора парсером pocketbase совпадают с исходными
+func TestFilterStringRoundTrip(t *testing.T) {
+	values := []string{"", "it's", `a\'b`, `\'`, `''`, `a\\'b`, `C:\dir\file`, `\x`, "строка с 'кавычками'", "{:v}"}
+	for _, value := range values {
+		literal, err := quoteValue(value)
+		if err != nil {
+			t.Errorf("quoteValue(%q): %v", value, err)
+			continue
+		}
+		if got := unquoteFilterString(t, literal); got != value {
+			t.Errorf("значение %q после разбора %s стало %q", value, literal, got)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		params Params
+	}{
+		// "\" перед закрывающей кавычкой экранировал бы ее
+		{"слеш в конце", "name={:v}", Params{"v": `dir\`}},
+		{"экранированная кавычка в конце", "name={:v}", Params{"v": `a\'\`}},
+		{"нет параметра", "owner={:owner} && status={:status}", Params{"owner": "abc"}},
+		{"несериализуемое значение", "name={:v}", Params{"v": make(chan int)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Filter(tt.expr, tt.params)
+			if err == nil {
+				t.Errorf("ожидалась ошибка, получен фильтр %s", got)
+			}
+		})
+	}
+}
+
+func TestListQueryValues(t *testing.T) {
+	filter, err := Filter("owner={:owner} && status!={:status}", Params{"owner": "a b&c=d", "status": "it's"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := ListQuery{Filter: filter, Sort: "-created,id", Page: 2, PerPage: 50}
+	want := "filter=owner%3D%27a+b%26c%3Dd%27+%26%26+status%21%3D%27it%5C%27s%27&page=2&perPage=50&sort=-created%2Cid"
+	if got := query.values().Encode(); got != want {
+		t.Errorf("values().Encode() = %s\nожидалось %s", got, want)
+	}
+
+	if got := (ListQuery{}).values().Encode(); got != "" {
+		t.Errorf("пустой запрос: %s", got)
+	}
+}
@@ -0,0 +1,5 @@
+module pbclient
+
+go 1.23.3
+
+require github.com/joho/godotenv v1.5.1
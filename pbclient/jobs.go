@@ -0,0 +1,22 @@
+package pbclient
+
+import "fmt"
+
+// Запрос к маршрутам задач /api/jobs/:collection[/:id]/:action из pocketbase/pb_hooks.
+// 409 для этих маршрутов - штатная ситуация, его можно проверить через StatusCode.
+func (c *Client) JobAction(collection, id, action string, payload, out interface{}) error {
+	path := fmt.Sprintf("/api/jobs/%s/%s", collection, action)
+	if id != "" {
+		path = fmt.Sprintf("/api/jobs/%s/%s/%s", collection, id, action)
+	}
+	return c.Send("POST", path, payload, out)
+}
+
+// Цены задач в монетах по коллекциям (/api/jobs/prices)
+func (c *Client) JobPrices() (map[string]int, error) {
+	var prices map[string]int
+	if err := c.Send("GET", "/api/jobs/prices", nil, &prices); err != nil {
+		return nil, fmt.Errorf("ошибка получения цен задач: %w", err)
+	}
+	return prices, nil
+}
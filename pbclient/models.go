@@ -0,0 +1,135 @@
+package pbclient
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// формат дат pocketbase
+const DateLayout = "2006-01-02 15:04:05.000Z"
+
+// DateTime - дата pocketbase; пустая строка соответствует нулевому времени
+type DateTime struct {
+	time.Time
+}
+
+// Дата в UTC для записи в поле pocketbase
+func NewDateTime(t time.Time) DateTime {
+	return DateTime{Time: t.UTC()}
+}
+
+// Дата в формате pocketbase, для нулевой даты - пустая строка
+func (d DateTime) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.UTC().Format(DateLayout)
+}
+
+func (d DateTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *DateTime) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+
+	parsed, err := time.Parse(DateLayout, value)
+	if err != nil {
+		return err
+	}
+	d.Time = parsed
+	return nil
+}
+
+// Record - системные поля любой записи
+type Record struct {
+	ID      string   `json:"id"`
+	Created DateTime `json:"created"`
+	Updated DateTime `json:"updated"`
+}
+
+// User - запись коллекции users
+type User struct {
+	Record
+	TGID             int    `json:"tgid"`
+	Username         string `json:"username"`
+	CircleCount      int    `json:"circle_count"`
+	FaceReplaceCount int    `json:"face_replace_count"`
+	Coins            int    `json:"coins"`
+}
+
+// Job - поля, общие для circle_jobs и face_jobs
+type Job struct {
+	Record
+	Owner          string   `json:"owner"`
+	InputMedia     string   `json:"input_media"`
+	OutputMedia    string   `json:"output_media"`
+	Status         string   `json:"status"`
+	WorkerID       string   `json:"worker_id"`
+	ClaimedAt      DateTime `json:"claimed_at"`
+	LeaseExpiresAt DateTime `json:"lease_expires_at"`
+	Attempts       int      `json:"attempts"`
+	ErrorMessage   string   `json:"error_message"`
+	ErrorStage     string   `json:"error_stage"`
+	FailedAt       DateTime `json:"failed_at"`
+	NextAttemptAt  DateTime `json:"next_attempt_at"`
+	CompletedAt    DateTime `json:"completed_at"`
+	// цена в монетах и состояние оплаты: reserved, charged или refunded
+	Price   int    `json:"price"`
+	Billing string `json:"billing"`
+	// прогресс обработки 0-100 и сообщение о статусе, которое редактирует бот
+	Progress        int   `json:"progress"`
+	StatusChatID    int64 `json:"status_chat_id"`
+	StatusMessageID int   `json:"status_message_id"`
+}
+
+// CircleJob - запись коллекции circle_jobs
+type CircleJob struct {
+	Job
+	// вид сообщения с исходным видео (video, video_note, animation, document) и MIME-тип файла
+	InputKind string `json:"input_kind"`
+	InputMime string `json:"input_mime"`
+}
+
+// FaceJob - запись коллекции face_jobs
+type FaceJob struct {
+	Job
+	InputFace        string `json:"input_face"`
+	MediaTransformed string `json:"media_transformed"`
+}
+
+// LedgerEntry - запись журнала монет coin_ledger
+type LedgerEntry struct {
+	Record
+	User          string `json:"user"`
+	Kind          string `json:"kind"`    // reserve, charge или refund
+	Amount        int    `json:"amount"`  // изменение баланса
+	Balance       int    `json:"balance"` // баланс после изменения
+	JobCollection string `json:"job_collection"`
+	Job           string `json:"job"`
+}
+
+// Session - состояние диалога пользователя с ботом (коллекция sessions)
+type Session struct {
+	Record
+	TGID      int               `json:"tgid"`
+	State     string            `json:"state"`
+	Data      map[string]string `json:"data"`
+	ExpiresAt DateTime          `json:"expires_at"`
+}
+
+// названия коллекций
+const (
+	UsersCollection      = "users"
+	CircleJobsCollection = "circle_jobs"
+	FaceJobsCollection   = "face_jobs"
+	CoinLedgerCollection = "coin_ledger"
+	SessionsCollection   = "sessions"
+)
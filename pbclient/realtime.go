@@ -0,0 +1,191 @@
+package pbclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// максимальная пауза перед переподключением realtime
+const maxRealtimeBackoff = time.Minute
+
+// RecordEvent - событие realtime об изменении записи коллекции
+type RecordEvent struct {
+	Action string          `json:"action"` // create, update или delete
+	Record json.RawMessage `json:"record"`
+}
+
+// Разбор записи события в out
+func (e RecordEvent) Decode(out interface{}) error {
+	return json.Unmarshal(e.Record, out)
+}
+
+// Realtime - подписка на realtime API pocketbase (SSE) с переподключением
+type Realtime struct {
+	client *Client
+	topics []string
+
+	// OnEvent вызывается для каждого события подписки; topic - коллекция (или "коллекция/id")
+	OnEvent func(topic string, event RecordEvent)
+	// OnConnect вызывается после оформления подписки: события, пришедшие без нее, потеряны
+	OnConnect func()
+	// OnDisconnect вызывается при обрыве подписки перед паузой retryIn
+	OnDisconnect func(err error, retryIn time.Duration)
+
+	connected atomic.Bool
+}
+
+// Подписка на коллекции или отдельные записи ("коллекция/id"); запускается через Run
+func (c *Client) Realtime(topics ...string) *Realtime {
+	return &Realtime{client: c, topics: topics}
+}
+
+// Подписка активна
+func (r *Realtime) Connected() bool {
+	return r.connected.Load()
+}
+
+// Подписка с переподключением, пока не отменен ctx
+func (r *Realtime) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := r.subscribe(ctx)
+		// обрыв после оформленной подписки (pocketbase закрывает простаивающие соединения) -
+		// не ошибка подключения, пауза считается заново
+		if r.connected.Swap(false) {
+			backoff = time.Second
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if r.OnDisconnect != nil {
+			r.OnDisconnect(err, backoff)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxRealtimeBackoff)
+	}
+}
+
+// Одно подключение к /api/realtime: PB_CONNECT, подписка на темы и чтение событий
+func (r *Realtime) subscribe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.client.URL+"/api/realtime", nil)
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса: %v", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := r.client.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка подключения: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ошибка подключения: статус %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		event, data, err := readEvent(reader)
+		if err != nil {
+			return err
+		}
+
+		if event == "PB_CONNECT" {
+			err = r.setSubscriptions(ctx, data)
+			if err != nil {
+				return err
+			}
+			r.connected.Store(true)
+			if r.OnConnect != nil {
+				r.OnConnect()
+			}
+			continue
+		}
+
+		var message RecordEvent
+		if err := json.Unmarshal(data, &message); err != nil {
+			log.Printf("Realtime: ошибка разбора события %s: %v", event, err)
+			continue
+		}
+		if r.OnEvent != nil {
+			r.OnEvent(event, message)
+		}
+	}
+}
+
+// Передача pocketbase списка тем для клиента из PB_CONNECT
+func (r *Realtime) setSubscriptions(ctx context.Context, connectData []byte) error {
+	var connect struct {
+		ClientID string `json:"clientId"`
+	}
+	if err := json.Unmarshal(connectData, &connect); err != nil || connect.ClientID == "" {
+		return fmt.Errorf("некорректное событие PB_CONNECT: %s", string(connectData))
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"clientId":      connect.ClientID,
+		"subscriptions": r.topics,
+	})
+
+	resp, err := r.client.Do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", r.client.URL+"/api/realtime", bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания запроса: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки подписки: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ошибка подписки: %w", newError(resp.StatusCode, body))
+	}
+	return nil
+}
+
+// Чтение одного SSE события: строки "event:" и "data:" до пустой строки
+func readEvent(reader *bufio.Reader) (string, []byte, error) {
+	var event string
+	var data bytes.Buffer
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if event == "" && data.Len() == 0 {
+				continue
+			}
+			return event, data.Bytes(), nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
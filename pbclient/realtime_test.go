@@ -0,0 +1,241 @@
+package pbclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sseServer - замена /api/realtime pocketbase: PB_CONNECT, прием подписки и события из events,
+// после которых поток обрывается
+type sseServer struct {
+	events []string
+
+	mu            sync.Mutex
+	clientID      string
+	subscriptions []string
+	subscribed    chan struct{}
+}
+
+func newSSEServer(events ...string) *sseServer {
+	return &sseServer{events: events, subscribed: make(chan struct{}, 1)}
+}
+
+func (s *sseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/realtime" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var data struct {
+			ClientID      string   `json:"clientId"`
+			Subscriptions []string `json:"subscriptions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.clientID = data.ClientID
+		s.subscriptions = data.Subscriptions
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		s.subscribed <- struct{}{}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher := w.(http.Flusher)
+	fmt.Fprint(w, "id:c1\nevent:PB_CONNECT\ndata:{\"clientId\":\"c1\"}\n\n")
+	flusher.Flush()
+
+	select {
+	case <-s.subscribed:
+	case <-time.After(5 * time.Second):
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	for _, event := range s.events {
+		fmt.Fprint(w, event)
+		flusher.Flush()
+	}
+	// возврат из обработчика обрывает поток
+}
+
+func sseRecord(topic, action, id, status string) string {
+	return fmt.Sprintf("event:%s\ndata:{\"action\":%q,\"record\":{\"id\":%q,\"status\":%q}}\n\n", topic, action, id, status)
+}
+
+func TestRealtimeEventsAndDisconnect(t *testing.T) {
+	server := newSSEServer(
+		sseRecord("circle_jobs", "create", "job1", "queued"),
+		// данные события в нескольких строках data:
+		"event:circle_jobs\ndata:{\"action\":\"update\",\ndata:\"record\":{\"id\":\"job2\",\"status\":\"cancelled\"}}\n\n",
+	)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := New(ts.URL, "admin@example.com", "password")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type received struct {
+		topic, action, id, status string
+		connected                 bool
+	}
+	var events []received
+	connects := 0
+	var disconnectErr error
+	connectedAfterDrop := true
+
+	r := client.Realtime("circle_jobs", "face_jobs")
+	r.OnConnect = func() { connects++ }
+	r.OnEvent = func(topic string, event RecordEvent) {
+		var record struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		}
+		if err := event.Decode(&record); err != nil {
+			t.Errorf("Decode: %v", err)
+		}
+		events = append(events, received{topic, event.Action, record.ID, record.Status, r.Connected()})
+	}
+	r.OnDisconnect = func(err error, retryIn time.Duration) {
+		disconnectErr = err
+		connectedAfterDrop = r.Connected()
+		if retryIn != time.Second {
+			t.Errorf("первая пауза переподключения %s, ожидалась 1s", retryIn)
+		}
+		cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run не завершился после обрыва потока")
+	}
+
+	server.mu.Lock()
+	if server.clientID != "c1" || fmt.Sprint(server.subscriptions) != "[circle_jobs face_jobs]" {
+		t.Errorf("подписка: clientId=%q subscriptions=%v", server.clientID, server.subscriptions)
+	}
+	server.mu.Unlock()
+
+	if connects != 1 {
+		t.Errorf("OnConnect вызван %d раз", connects)
+	}
+	want := []received{
+		{"circle_jobs", "create", "job1", "queued", true},
+		{"circle_jobs", "update", "job2", "cancelled", true},
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("события %v, ожидались %v", events, want)
+	}
+	if disconnectErr == nil {
+		t.Error("OnDisconnect без ошибки обрыва")
+	}
+	if connectedAfterDrop || r.Connected() {
+		t.Error("Connected() остался true после обрыва потока")
+	}
+}
+
+func TestRealtimeSubscriptionRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":400,"message":"Invalid client id.","data":{}}`)
+			return
+		}
+		fmt.Fprint(w, "event:PB_CONNECT\ndata:{\"clientId\":\"c1\"}\n\n")
+	}))
+	defer ts.Close()
+
+	r := New(ts.URL, "admin@example.com", "password").Realtime("circle_jobs")
+	err := r.subscribe(context.Background())
+	if !IsValidation(err) {
+		t.Fatalf("ожидалась ошибка валидации, получено %v", err)
+	}
+	if r.Connected() {
+		t.Error("Connected() true без подписки")
+	}
+}
+
+// pocketbase закрывает простаивающий поток каждые несколько минут; после удачной подписки
+// пауза переподключения не растет
+func TestRealtimeBackoffResetsAfterSubscribe(t *testing.T) {
+	ts := httptest.NewServer(newSSEServer(sseRecord("circle_jobs", "create", "job1", "queued")))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const drops = 3
+	var delays []time.Duration
+	connects := 0
+	r := New(ts.URL, "admin@example.com", "password").Realtime("circle_jobs")
+	r.OnConnect = func() { connects++ }
+	r.OnDisconnect = func(err error, retryIn time.Duration) {
+		delays = append(delays, retryIn)
+		if len(delays) == drops {
+			cancel()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run не завершился")
+	}
+
+	if connects != drops {
+		t.Errorf("OnConnect вызван %d раз, ожидалось %d", connects, drops)
+	}
+	for i, delay := range delays {
+		if delay != time.Second {
+			t.Errorf("пауза после обрыва %d: %s, ожидалась 1s", i+1, delay)
+		}
+	}
+}
+
+// Без подписки пауза удваивается
+func TestRealtimeBackoffGrowsWithoutSubscribe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var delays []time.Duration
+	r := New(ts.URL, "admin@example.com", "password").Realtime("circle_jobs")
+	r.OnDisconnect = func(err error, retryIn time.Duration) {
+		delays = append(delays, retryIn)
+		if len(delays) == 2 {
+			cancel()
+		}
+	}
+	r.Run(ctx)
+
+	if fmt.Sprint(delays) != "[1s 2s]" {
+		t.Errorf("паузы %v, ожидались [1s 2s]", delays)
+	}
+}
@@ -0,0 +1,217 @@
+package pbclient
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// максимальный perPage, который принимает pocketbase
+const maxPerPage = 500
+
+// ListQuery - параметры запроса списка записей
+type ListQuery struct {
+	Filter  string
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+func (q ListQuery) values() url.Values {
+	values := url.Values{}
+	if q.Filter != "" {
+		values.Set("filter", q.Filter)
+	}
+	if q.Sort != "" {
+		values.Set("sort", q.Sort)
+	}
+	if q.Page > 0 {
+		values.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PerPage > 0 {
+		values.Set("perPage", strconv.Itoa(q.PerPage))
+	}
+	return values
+}
+
+// ListResult - страница записей
+type ListResult[T any] struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"perPage"`
+	TotalItems int `json:"totalItems"`
+	TotalPages int `json:"totalPages"`
+	Items      []T `json:"items"`
+}
+
+// Одна страница записей коллекции
+func List[T any](c *Client, collection string, query ListQuery) (*ListResult[T], error) {
+	path := fmt.Sprintf("/api/collections/%s/records?%s", collection, query.values().Encode())
+
+	var result ListResult[T]
+	if err := c.Send("GET", path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Все записи коллекции по фильтру, постранично
+func ListAll[T any](c *Client, collection string, query ListQuery) ([]T, error) {
+	if query.PerPage <= 0 {
+		query.PerPage = maxPerPage
+	}
+
+	var items []T
+	for page := 1; ; page++ {
+		query.Page = page
+		result, err := List[T](c, collection, query)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, result.Items...)
+		if page >= result.TotalPages || len(result.Items) == 0 {
+			return items, nil
+		}
+	}
+}
+
+// Первая запись по фильтру; ErrNotFound, если таких нет
+func First[T any](c *Client, collection, filter string) (*T, error) {
+	result, err := List[T](c, collection, ListQuery{Filter: filter, PerPage: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, fmt.Errorf("%s по фильтру %s: %w", collection, filter, ErrNotFound)
+	}
+	return &result.Items[0], nil
+}
+
+// Запись по ID
+func Get[T any](c *Client, collection, id string) (*T, error) {
+	var record T
+	if err := c.Send("GET", recordPath(collection, id), nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Создание записи; созданная запись разбирается в out, если он не nil
+func (c *Client) Create(collection string, data, out interface{}) error {
+	return c.Send("POST", recordPath(collection, ""), data, out)
+}
+
+// Изменение полей записи
+func (c *Client) Update(collection, id string, data, out interface{}) error {
+	return c.Send("PATCH", recordPath(collection, id), data, out)
+}
+
+// Удаление записи
+func (c *Client) Delete(collection, id string) error {
+	return c.Send("DELETE", recordPath(collection, id), nil, nil)
+}
+
+// File - файл для поля записи
+type File struct {
+	Field string
+	Path  string
+}
+
+// Создание записи с файлами (multipart/form-data)
+func (c *Client) CreateWithFiles(collection string, fields map[string]string, files []File, out interface{}) error {
+	return c.sendMultipart("POST", recordPath(collection, ""), fields, files, out)
+}
+
+// Изменение записи с загрузкой файлов
+func (c *Client) UpdateWithFiles(collection, id string, fields map[string]string, files []File, out interface{}) error {
+	return c.sendMultipart("PATCH", recordPath(collection, id), fields, files, out)
+}
+
+// Ссылка на файл записи
+func (c *Client) FileURL(collection, id, fileName string) string {
+	return fmt.Sprintf("%s/api/files/%s/%s/%s", c.URL, collection, id, url.PathEscape(fileName))
+}
+
+func recordPath(collection, id string) string {
+	if id == "" {
+		return fmt.Sprintf("/api/collections/%s/records", collection)
+	}
+	return fmt.Sprintf("/api/collections/%s/records/%s", collection, id)
+}
+
+// Тело не собирается в памяти: для каждой попытки (в том числе после повторной авторизации)
+// оно заново пишется из файлов прямо в соединение через io.Pipe
+func (c *Client) sendMultipart(method, path string, fields map[string]string, files []File, out interface{}) error {
+	// ошибку открытия файла лучше получить до отправки, а не обрывом тела запроса
+	for _, f := range files {
+		if _, err := os.Stat(f.Path); err != nil {
+			return fmt.Errorf("ошибка открытия файла: %v", err)
+		}
+	}
+
+	resp, err := c.Do(func() (*http.Request, error) {
+		body, pipe := io.Pipe()
+		writer := multipart.NewWriter(pipe)
+
+		req, err := http.NewRequest(method, c.URL+path, body)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания запроса: %v", err)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		// HTTP-клиент закрывает тело запроса, даже если не дочитал его, и запись прерывается
+		go func() {
+			pipe.CloseWithError(writeMultipart(writer, fields, files))
+		}()
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readResponse(resp, out)
+}
+
+// Поля и файлы формы; writer закрывается
+func writeMultipart(writer *multipart.Writer, fields map[string]string, files []File) error {
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return fmt.Errorf("ошибка добавления поля %s: %v", name, err)
+		}
+	}
+
+	for _, f := range files {
+		if err := addFile(writer, f); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("ошибка завершения multipart: %v", err)
+	}
+	return nil
+}
+
+func addFile(writer *multipart.Writer, f File) error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия файла: %v", err)
+	}
+	defer file.Close()
+
+	part, err := writer.CreateFormFile(f.Field, filepath.Base(f.Path))
+	if err != nil {
+		return fmt.Errorf("ошибка добавления файла в запрос: %v", err)
+	}
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return fmt.Errorf("ошибка копирования содержимого файла: %v", err)
+	}
+	return nil
+}
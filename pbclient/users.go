@@ -0,0 +1,22 @@
+package pbclient
+
+import "fmt"
+
+// Пользователь по Telegram ID; ErrNotFound, если его нет
+func (c *Client) FindUserByTGID(tgUserID int) (*User, error) {
+	filter, err := Filter("tgid={:tgid}", Params{"tgid": tgUserID})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := First[User](c, UsersCollection, filter)
+	if err != nil {
+		return nil, fmt.Errorf("пользователь с Telegram ID %d: %w", tgUserID, err)
+	}
+	return user, nil
+}
+
+// Пользователь по ID записи
+func (c *Client) GetUser(id string) (*User, error) {
+	return Get[User](c, UsersCollection, id)
+}
@@ -0,0 +1,88 @@
+/// <reference path="../pb_data/types.d.ts" />
+// поля захвата задач воркерами job-manager
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "onw1u7xm",
+        name: "worker_id",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "lvvgbn0g",
+        name: "claimed_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "xmq499x5",
+        name: "worker_id",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "tkd9i9yx",
+        name: "claimed_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    dao.saveCollection(faceJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("onw1u7xm");
+    circleJobs.schema.removeField("lvvgbn0g");
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.removeField("xmq499x5");
+    faceJobs.schema.removeField("tkd9i9yx");
+    dao.saveCollection(faceJobs);
+  },
+);
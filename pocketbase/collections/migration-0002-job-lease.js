@@ -0,0 +1,88 @@
+/// <reference path="../pb_data/types.d.ts" />
+// аренда задач: срок аренды и счетчик попыток
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "3nib4866",
+        name: "lease_expires_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "u6g6qsp5",
+        name: "attempts",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "2st1vsyk",
+        name: "lease_expires_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "3l27aroa",
+        name: "attempts",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    dao.saveCollection(faceJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("3nib4866");
+    circleJobs.schema.removeField("u6g6qsp5");
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.removeField("2st1vsyk");
+    faceJobs.schema.removeField("3l27aroa");
+    dao.saveCollection(faceJobs);
+  },
+);
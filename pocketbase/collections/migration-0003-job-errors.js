@@ -0,0 +1,122 @@
+/// <reference path="../pb_data/types.d.ts" />
+// структурированные ошибки задач: сообщение, этап и время
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "dob3d4dw",
+        name: "error_message",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "rxyypoxj",
+        name: "error_stage",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "vll7asxu",
+        name: "failed_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "n8b3oces",
+        name: "error_message",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "qgmphjfv",
+        name: "error_stage",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "pbyluahb",
+        name: "failed_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    dao.saveCollection(faceJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("dob3d4dw");
+    circleJobs.schema.removeField("rxyypoxj");
+    circleJobs.schema.removeField("vll7asxu");
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.removeField("n8b3oces");
+    faceJobs.schema.removeField("qgmphjfv");
+    faceJobs.schema.removeField("pbyluahb");
+    dao.saveCollection(faceJobs);
+  },
+);
@@ -0,0 +1,54 @@
+/// <reference path="../pb_data/types.d.ts" />
+// время следующей попытки для повтора задач с временной ошибкой
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "al36id75",
+        name: "next_attempt_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "kj1nicco",
+        name: "next_attempt_at",
+        type: "date",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: "",
+          max: "",
+        },
+      }),
+    );
+    dao.saveCollection(faceJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("al36id75");
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.removeField("kj1nicco");
+    dao.saveCollection(faceJobs);
+  },
+);
@@ -0,0 +1,7 @@
+/// <reference path="../pb_data/types.d.ts" />
+// Пустая миграция: поле retries больше не используется, повтор задачи учитывается в attempts при захвате.
+// Файл оставлен, чтобы номера миграций шли подряд.
+migrate(
+  (db) => {},
+  (db) => {},
+);
@@ -0,0 +1,125 @@
+/// <reference path="../pb_data/types.d.ts" />
+// прогресс обработки задачи (0-100), который пишет job-manager,
+// и сообщение о статусе в Telegram, которое telegram-bot редактирует по мере обработки.
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "m3ufxx9u",
+        name: "progress",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: 0,
+          max: 100,
+          noDecimal: true,
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "htfbe8is",
+        name: "status_chat_id",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "x9p1tvr8",
+        name: "status_message_id",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "m7luv1ym",
+        name: "progress",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: 0,
+          max: 100,
+          noDecimal: true,
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "asf78syd",
+        name: "status_chat_id",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "vir85pm9",
+        name: "status_message_id",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    dao.saveCollection(faceJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("m3ufxx9u");
+    circleJobs.schema.removeField("htfbe8is");
+    circleJobs.schema.removeField("x9p1tvr8");
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.removeField("m7luv1ym");
+    faceJobs.schema.removeField("asf78syd");
+    faceJobs.schema.removeField("vir85pm9");
+    dao.saveCollection(faceJobs);
+  },
+);
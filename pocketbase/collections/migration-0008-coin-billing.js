@@ -0,0 +1,196 @@
+/// <reference path="../pb_data/types.d.ts" />
+// оплата задач монетами: цена и состояние оплаты задачи (reserved, charged, refunded)
+// и журнал coin_ledger со всеми изменениями баланса пользователей
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const coinLedger = new Collection({
+      id: "c01nl3dg3r4k7q2",
+      name: "coin_ledger",
+      type: "base",
+      system: false,
+      schema: [
+        {
+          system: false,
+          id: "cvd096rg",
+          name: "user",
+          type: "relation",
+          required: true,
+          presentable: false,
+          unique: false,
+          options: {
+            collectionId: "ojssopdqy5r541p",
+            cascadeDelete: false,
+            minSelect: null,
+            maxSelect: 1,
+            displayFields: null,
+          },
+        },
+        {
+          system: false,
+          id: "kbj9nnnd",
+          name: "kind",
+          type: "text",
+          required: true,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            pattern: "",
+          },
+        },
+        {
+          system: false,
+          id: "ychhsen8",
+          name: "amount",
+          type: "number",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            noDecimal: true,
+          },
+        },
+        {
+          system: false,
+          id: "slh7maop",
+          name: "balance",
+          type: "number",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            noDecimal: true,
+          },
+        },
+        {
+          system: false,
+          id: "gugspg8q",
+          name: "job_collection",
+          type: "text",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            pattern: "",
+          },
+        },
+        {
+          system: false,
+          id: "e8n10yyk",
+          name: "job",
+          type: "text",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            pattern: "",
+          },
+        },
+      ],
+      indexes: ["CREATE INDEX `idx_coin_ledger_user` ON `coin_ledger` (`user`)"],
+      listRule: null,
+      viewRule: null,
+      createRule: null,
+      updateRule: null,
+      deleteRule: null,
+      options: {},
+    });
+    dao.saveCollection(coinLedger);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "o205fqb9",
+        name: "price",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: 0,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "sma5pouh",
+        name: "billing",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "dvwhizvo",
+        name: "price",
+        type: "number",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: 0,
+          max: null,
+          noDecimal: true,
+        },
+      }),
+    );
+    faceJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "689lzhn7",
+        name: "billing",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    dao.saveCollection(faceJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("o205fqb9");
+    circleJobs.schema.removeField("sma5pouh");
+    dao.saveCollection(circleJobs);
+
+    const faceJobs = dao.findCollectionByNameOrId("9r47cxfzaoclhq6");
+    faceJobs.schema.removeField("dvwhizvo");
+    faceJobs.schema.removeField("689lzhn7");
+    dao.saveCollection(faceJobs);
+
+    dao.deleteCollection(dao.findCollectionByNameOrId("c01nl3dg3r4k7q2"));
+  },
+);
@@ -0,0 +1,82 @@
+/// <reference path="../pb_data/types.d.ts" />
+// состояние диалога пользователя с ботом (FSM telegram-bot): шаг, данные шага и срок действия
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const sessions = new Collection({
+      id: "s3ss10nsfsm7x2q",
+      name: "sessions",
+      type: "base",
+      system: false,
+      schema: [
+        {
+          system: false,
+          id: "eo13ga7y",
+          name: "tgid",
+          type: "number",
+          required: true,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            noDecimal: true,
+          },
+        },
+        {
+          system: false,
+          id: "w8pa0rlr",
+          name: "state",
+          type: "text",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            min: null,
+            max: null,
+            pattern: "",
+          },
+        },
+        {
+          system: false,
+          id: "0kxevkzx",
+          name: "data",
+          type: "json",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            maxSize: 2000000,
+          },
+        },
+        {
+          system: false,
+          id: "x0pafrys",
+          name: "expires_at",
+          type: "date",
+          required: false,
+          presentable: false,
+          unique: false,
+          options: {
+            min: "",
+            max: "",
+          },
+        },
+      ],
+      indexes: ["CREATE UNIQUE INDEX `idx_sessions_tgid` ON `sessions` (`tgid`)"],
+      listRule: null,
+      viewRule: null,
+      createRule: null,
+      updateRule: null,
+      deleteRule: null,
+      options: {},
+    });
+    dao.saveCollection(sessions);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    dao.deleteCollection(dao.findCollectionByNameOrId("s3ss10nsfsm7x2q"));
+  },
+);
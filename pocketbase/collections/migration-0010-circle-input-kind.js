@@ -0,0 +1,51 @@
+/// <reference path="../pb_data/types.d.ts" />
+// тип исходного сообщения (video, video_note, animation, document) и MIME-тип файла кружка,
+// которые принял telegram-bot
+migrate(
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "kdnh0kib",
+        name: "input_kind",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    circleJobs.schema.addField(
+      new SchemaField({
+        system: false,
+        id: "o4qnm343",
+        name: "input_mime",
+        type: "text",
+        required: false,
+        presentable: false,
+        unique: false,
+        options: {
+          min: null,
+          max: null,
+          pattern: "",
+        },
+      }),
+    );
+    dao.saveCollection(circleJobs);
+  },
+  (db) => {
+    const dao = new Dao(db);
+
+    const circleJobs = dao.findCollectionByNameOrId("2dtkk2h5xo817br");
+    circleJobs.schema.removeField("kdnh0kib");
+    circleJobs.schema.removeField("o4qnm343");
+    dao.saveCollection(circleJobs);
+  },
+);
@@ -0,0 +1,145 @@
+// общие функции для маршрутов задач.
+// обработчики pocketbase выполняются в изолированном контексте,
+// поэтому подключаются через require(`${__hooks}/jobs.js`).
+
+// коллекции, с которыми работают воркеры job-manager
+const JOB_COLLECTIONS = ["circle_jobs", "face_jobs"];
+
+// имя коллекции из пути запроса, только из списка задач
+function jobCollection(c) {
+  const collection = c.pathParam("collection");
+  if (!JOB_COLLECTIONS.includes(collection)) {
+    throw new NotFoundError("unknown job collection", {});
+  }
+  return collection;
+}
+
+function findJob(dao, collection, id) {
+  try {
+    return dao.findRecordById(collection, id);
+  } catch (_) {
+    throw new NotFoundError("job not found", {});
+  }
+}
+
+// статусы, в которых задача принадлежит воркеру и должна продлевать аренду
+const LEASED_STATUSES = ["processing", "sending"];
+
+// статусы, из которых задачу может отменить пользователь;
+// в "sending" результат уже готов и отправляется
+const CANCELLABLE_STATUSES = ["queued", "processing"];
+
+// статусы, из которых пользователь может вернуть задачу в очередь (/retry)
+const RETRYABLE_STATUSES = ["failed", "dead", "cancelled"];
+
+// цена задачи в монетах по коллекции
+const JOB_PRICES = { circle_jobs: 10, face_jobs: 50 };
+
+// статусы, в которых задача больше не выполнится: зарезервированные монеты возвращаются
+const REFUNDABLE_STATUSES = ["failed", "dead", "cancelled"];
+
+// срок аренды по умолчанию, если воркер его не передал
+const DEFAULT_LEASE_SECONDS = 60;
+
+// 409 - задача уже в другом состоянии или принадлежит другому воркеру
+function conflict(message) {
+  return new ApiError(409, message, {});
+}
+
+// 410 - задачу отменили, воркер должен прекратить обработку
+function cancelled() {
+  return new ApiError(410, "job is cancelled", {});
+}
+
+// 402 - у владельца не хватает монет на задачу
+function paymentRequired(price, coins) {
+  return new ApiError(402, `insufficient coins: price ${price}, balance ${coins}`, {});
+}
+
+// запись в coin_ledger: amount - изменение баланса, balance - баланс после него
+function addLedgerEntry(dao, user, kind, amount, collection, jobId) {
+  const ledger = dao.findCollectionByNameOrId("coin_ledger");
+  const entry = new Record(ledger);
+  entry.set("user", user.getId());
+  entry.set("kind", kind);
+  entry.set("amount", amount);
+  entry.set("balance", user.getInt("coins"));
+  entry.set("job_collection", collection);
+  entry.set("job", jobId);
+  dao.saveRecord(entry);
+}
+
+// списание цены задачи с баланса владельца до ее выполнения; запись задачи сохраняет вызывающий
+function reserveCoins(dao, collection, record) {
+  const price = JOB_PRICES[collection];
+  const user = dao.findRecordById("users", record.getString("owner"));
+  const coins = user.getInt("coins");
+  if (coins < price) {
+    throw paymentRequired(price, coins);
+  }
+
+  user.set("coins", coins - price);
+  dao.saveRecord(user);
+  addLedgerEntry(dao, user, "reserve", -price, collection, record.getId());
+
+  record.set("price", price);
+  record.set("billing", "reserved");
+}
+
+// расчет по зарезервированной задаче: списание при успехе, возврат при сбое или отмене
+function settleCoins(dao, collection, record) {
+  if (record.getString("billing") !== "reserved") {
+    return;
+  }
+
+  const status = record.getString("status");
+  const price = record.getInt("price");
+  const user = dao.findRecordById("users", record.getString("owner"));
+  if (status === "completed") {
+    addLedgerEntry(dao, user, "charge", 0, collection, record.getId());
+    record.set("billing", "charged");
+  } else if (REFUNDABLE_STATUSES.includes(status)) {
+    user.set("coins", user.getInt("coins") + price);
+    dao.saveRecord(user);
+    addLedgerEntry(dao, user, "refund", price, collection, record.getId());
+    record.set("billing", "refunded");
+  } else {
+    return;
+  }
+  dao.saveRecord(record);
+}
+
+// дата в формате pocketbase ("2006-01-02 15:04:05.000Z") через ms миллисекунд от текущего момента
+function pbDate(ms) {
+  return new Date(Date.now() + (ms || 0)).toISOString().replace("T", " ");
+}
+
+function leaseSeconds(data) {
+  const seconds = parseInt(data.lease_seconds, 10);
+  return seconds > 0 ? seconds : DEFAULT_LEASE_SECONDS;
+}
+
+// задача принадлежит воркеру, пока он держит аренду
+function ownedBy(record, workerId) {
+  return (
+    LEASED_STATUSES.includes(record.getString("status")) &&
+    record.getString("worker_id") === workerId
+  );
+}
+
+module.exports = {
+  JOB_COLLECTIONS,
+  LEASED_STATUSES,
+  CANCELLABLE_STATUSES,
+  RETRYABLE_STATUSES,
+  JOB_PRICES,
+  jobCollection,
+  findJob,
+  conflict,
+  cancelled,
+  reserveCoins,
+  settleCoins,
+  pbDate,
+  leaseSeconds,
+  ownedBy,
+};
@@ -0,0 +1,320 @@
+/// <reference path="../pb_data/types.d.ts" />
+
+// Атомарный захват задачи воркером.
+// Проверка статуса и запись worker_id выполняются в одной транзакции,
+// поэтому из нескольких воркеров задачу получает ровно один, остальные получают 409.
+// Захват выдает аренду на lease_seconds, которую воркер продлевает через heartbeat.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/:id/claim",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+    const id = c.pathParam("id");
+
+    const data = $apis.requestInfo(c).data;
+    const workerId = data.worker_id;
+    if (!workerId) {
+      throw new BadRequestError("worker_id is required", {});
+    }
+
+    let claimed = null;
+    $app.dao().runInTransaction((txDao) => {
+      const record = jobs.findJob(txDao, collection, id);
+      if (record.getString("status") !== "queued") {
+        throw jobs.conflict("job is not queued");
+      }
+
+      record.set("status", "processing");
+      record.set("worker_id", workerId);
+      record.set("claimed_at", new DateTime());
+      record.set("lease_expires_at", jobs.pbDate(jobs.leaseSeconds(data) * 1000));
+      record.set("attempts", record.getInt("attempts") + 1);
+      record.set("progress", 0);
+      txDao.saveRecord(record);
+      claimed = record;
+    });
+
+    return c.json(200, claimed);
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Продление аренды воркером, который держит задачу.
+// 409 означает, что аренда потеряна, 410 - что задачу отменили; в обоих случаях обработку нужно прекратить.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/:id/heartbeat",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+    const id = c.pathParam("id");
+
+    const data = $apis.requestInfo(c).data;
+    if (!data.worker_id) {
+      throw new BadRequestError("worker_id is required", {});
+    }
+
+    let leaseExpiresAt = "";
+    $app.dao().runInTransaction((txDao) => {
+      const record = jobs.findJob(txDao, collection, id);
+      if (record.getString("status") === "cancelled") {
+        throw jobs.cancelled();
+      }
+      if (!jobs.ownedBy(record, data.worker_id)) {
+        throw jobs.conflict("job lease is lost");
+      }
+
+      leaseExpiresAt = jobs.pbDate(jobs.leaseSeconds(data) * 1000);
+      record.set("lease_expires_at", leaseExpiresAt);
+      txDao.saveRecord(record);
+    });
+
+    return c.json(200, { lease_expires_at: leaseExpiresAt });
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Возврат задачи в очередь воркером, который ее держит (остановка job-manager).
+// Попытка не засчитывается: задача не виновата в перезапуске воркера.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/:id/release",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+    const id = c.pathParam("id");
+
+    const data = $apis.requestInfo(c).data;
+    if (!data.worker_id) {
+      throw new BadRequestError("worker_id is required", {});
+    }
+
+    $app.dao().runInTransaction((txDao) => {
+      const record = jobs.findJob(txDao, collection, id);
+      if (!jobs.ownedBy(record, data.worker_id)) {
+        throw jobs.conflict("job lease is lost");
+      }
+
+      record.set("status", "queued");
+      record.set("worker_id", "");
+      record.set("lease_expires_at", "");
+      record.set("attempts", Math.max(record.getInt("attempts") - 1, 0));
+      txDao.saveRecord(record);
+    });
+
+    return c.json(200, { status: "queued" });
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Возврат задач с истекшей арендой: в очередь, либо в "dead" после max_attempts попыток.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/reap",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+
+    const data = $apis.requestInfo(c).data;
+    const maxAttempts = parseInt(data.max_attempts, 10) || 0;
+
+    const result = { requeued: [], dead: [] };
+    $app.dao().runInTransaction((txDao) => {
+      const expired = txDao.findRecordsByFilter(
+        collection,
+        "(status = 'processing' || status = 'sending') && lease_expires_at != '' && lease_expires_at < {:now}",
+        "lease_expires_at",
+        100,
+        0,
+        { now: jobs.pbDate(0) },
+      );
+
+      for (const record of expired) {
+        if (maxAttempts > 0 && record.getInt("attempts") >= maxAttempts) {
+          record.set("status", "dead");
+          record.set("error_stage", "lease");
+          record.set(
+            "error_message",
+            `аренда воркера ${record.getString("worker_id")} истекла, попыток: ${record.getInt("attempts")}`,
+          );
+          record.set("failed_at", new DateTime());
+          result.dead.push(record.getId());
+        } else {
+          record.set("status", "queued");
+          result.requeued.push(record.getId());
+        }
+        record.set("worker_id", "");
+        record.set("lease_expires_at", "");
+        txDao.saveRecord(record);
+      }
+    });
+
+    return c.json(200, result);
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Отмена задачи пользователем (/cancel в telegram-bot).
+// owner - id пользователя из users: чужая задача выглядит как несуществующая.
+// Воркер узнает об отмене из realtime или по 410 на heartbeat и останавливает обработку.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/:id/cancel",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+    const id = c.pathParam("id");
+
+    const data = $apis.requestInfo(c).data;
+    if (!data.owner) {
+      throw new BadRequestError("owner is required", {});
+    }
+
+    let status = "";
+    $app.dao().runInTransaction((txDao) => {
+      const record = jobs.findJob(txDao, collection, id);
+      if (record.getString("owner") !== data.owner) {
+        throw new NotFoundError("job not found", {});
+      }
+
+      status = record.getString("status");
+      if (!jobs.CANCELLABLE_STATUSES.includes(status)) {
+        throw jobs.conflict(`job can not be cancelled in status ${status}`);
+      }
+
+      record.set("status", "cancelled");
+      record.set("lease_expires_at", "");
+      record.set("next_attempt_at", "");
+      txDao.saveRecord(record);
+    });
+
+    return c.json(200, { status: "cancelled", previous_status: status });
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Повтор задачи пользователем (/retry в telegram-bot): failed, dead или cancelled снова в очереди.
+// Поля ошибки очищаются, attempts не меняется: его увеличит захват задачи, история попыток сохраняется.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/:id/retry",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+    const id = c.pathParam("id");
+
+    const data = $apis.requestInfo(c).data;
+    if (!data.owner) {
+      throw new BadRequestError("owner is required", {});
+    }
+
+    let retried = null;
+    $app.dao().runInTransaction((txDao) => {
+      const record = jobs.findJob(txDao, collection, id);
+      if (record.getString("owner") !== data.owner) {
+        throw new NotFoundError("job not found", {});
+      }
+
+      const status = record.getString("status");
+      if (!jobs.RETRYABLE_STATUSES.includes(status)) {
+        throw jobs.conflict(`job can not be retried in status ${status}`);
+      }
+      // монеты за неудавшуюся задачу уже возвращены, повтор оплачивается заново
+      if (record.getString("billing") !== "reserved") {
+        jobs.reserveCoins(txDao, collection, record);
+      }
+
+      record.set("status", "queued");
+      record.set("worker_id", "");
+      record.set("lease_expires_at", "");
+      record.set("next_attempt_at", "");
+      record.set("error_stage", "");
+      record.set("error_message", "");
+      record.set("failed_at", "");
+      record.set("progress", 0);
+      txDao.saveRecord(record);
+      retried = record;
+    });
+
+    return c.json(200, retried);
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Цены задач в монетах по коллекциям (telegram-bot проверяет баланс до загрузки файла)
+routerAdd(
+  "GET",
+  "/api/jobs/prices",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    return c.json(200, jobs.JOB_PRICES);
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Оплата новой задачи (telegram-bot): задача создается в статусе pending с файлами,
+// цена списывается с баланса владельца, и задача попадает в очередь.
+// 402 - монет не хватает, задача остается в pending и удаляется ботом.
+routerAdd(
+  "POST",
+  "/api/jobs/:collection/:id/reserve",
+  (c) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const collection = jobs.jobCollection(c);
+    const id = c.pathParam("id");
+
+    const data = $apis.requestInfo(c).data;
+    if (!data.owner) {
+      throw new BadRequestError("owner is required", {});
+    }
+
+    let reserved = null;
+    $app.dao().runInTransaction((txDao) => {
+      const record = jobs.findJob(txDao, collection, id);
+      if (record.getString("owner") !== data.owner) {
+        throw new NotFoundError("job not found", {});
+      }
+
+      const status = record.getString("status");
+      if (status !== "pending") {
+        throw jobs.conflict(`job can not be paid in status ${status}`);
+      }
+
+      jobs.reserveCoins(txDao, collection, record);
+      record.set("status", "queued");
+      txDao.saveRecord(record);
+      reserved = record;
+    });
+
+    return c.json(200, reserved);
+  },
+  $apis.requireAdminAuth(),
+);
+
+// Расчет по задаче при любой смене статуса - из маршрутов /api/jobs, записи воркера или истечения аренды:
+// completed списывает зарезервированные монеты, failed, dead и cancelled возвращают их владельцу.
+// e.dao - dao сохранения, внутри транзакции маршрута это ее txDao.
+onModelAfterUpdate(
+  (e) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    jobs.settleCoins(e.dao, e.model.collection().name, e.model);
+  },
+  "circle_jobs",
+  "face_jobs",
+);
+
+// Отмененную задачу не может "оживить" запись воркера, который еще не заметил отмену
+// (загрузка результата, смена статуса, запись сбоя). Статус отмененной задачи меняют только
+// маршруты /api/jobs: они сохраняют запись через dao и этот обработчик не вызывают.
+onRecordBeforeUpdateRequest(
+  (e) => {
+    const jobs = require(`${__hooks}/jobs.js`);
+    const original = e.record.originalCopy();
+    if (original.getString("status") === "cancelled" && e.record.getString("status") !== "cancelled") {
+      throw jobs.conflict("job is cancelled");
+    }
+  },
+  "circle_jobs",
+  "face_jobs",
+);
@@ -0,0 +1,22 @@
+/// <reference path="../pb_data/types.d.ts" />
+
+// Удаление истекших сессий telegram-bot. Бот и сам не использует сессию после expires_at,
+// очистка только не дает коллекции расти.
+cronAdd("sessions_cleanup", "*/15 * * * *", () => {
+  const jobs = require(`${__hooks}/jobs.js`);
+
+  $app.dao().runInTransaction((txDao) => {
+    const expired = txDao.findRecordsByFilter(
+      "sessions",
+      "expires_at != '' && expires_at < {:now}",
+      "expires_at",
+      500,
+      0,
+      { now: jobs.pbDate(0) },
+    );
+
+    for (const record of expired) {
+      txDao.deleteRecord(record);
+    }
+  });
+});
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// лимит сообщений от одного пользователя
+const (
+	rateLimitMessages = 20
+	rateLimitWindow   = time.Minute
+)
+
+const helpMessage = "Напиши мне фото для создания задачи по замене лица (временно недоступно). Пришли видео, кружок, GIF или видеофайл для создания кружочка. Список задач: /status, история задач: /history, отмена задачи: /cancel <id>, повтор задачи с ошибкой: /retry <id>. Канал с новостями https://t.me/+HGQVwMhFzIExZDNi"
+
+// Команды бота, обработка медиа и middleware
+func newBotRouter() *router {
+	rt := newRouter()
+	rt.Use(withLogging)
+	rt.Use(newRateLimiter(rateLimitMessages, rateLimitWindow).middleware)
+	rt.Use(withUser)
+
+	rt.Handle("start", "Начало работы", func(r *request) error {
+		r.reply(fmt.Sprintf("Привет, %s! Добро пожаловать! Справка: /help", r.message.From.UserName))
+		return nil
+	})
+
+	rt.Handle("help", "Справка", func(r *request) error {
+		r.reply(helpMessage)
+		return nil
+	})
+
+	rt.Handle("status", "Баланс и активные задачи", func(r *request) error {
+		err := handleStatusCommand(r.bot, r.update)
+		if err != nil {
+			log.Printf("Не удалось получить статус пользователя: %v", err)
+			r.reply(fmt.Sprintf("Произошла ошибка при получении статуса: %v", err))
+		}
+		return nil
+	})
+
+	rt.Handle("history", "История задач", func(r *request) error {
+		handleHistoryCommand(r.bot, r.update, r.userID)
+		return nil
+	})
+
+	rt.Handle("cancel", "Отменить задачу: /cancel <id>", func(r *request) error {
+		// без ID - отмена начатого действия
+		if len(r.args) == 0 && r.session.State != stateIdle {
+			cancelFlow(r.bot, r.message.Chat.ID, r.session)
+			return nil
+		}
+		handleCancelCommand(r)
+		return nil
+	})
+
+	rt.Handle("retry", "Повторить задачу: /retry <id>", func(r *request) error {
+		handleRetryCommand(r)
+		return nil
+	})
+
+	rt.Fallback(handleMedia)
+	return rt
+}
@@ -1,81 +1,29 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"pbclient"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
 
-// getting JWT for pocketbase
-func authenticatePocketBase() error {
-	authData := map[string]string{
-		"identity": email,
-		"password": password,
-	}
-
-	authDataJson, _ := json.Marshal(authData)
-
-	authURL := fmt.Sprintf("%s/api/admins/auth-with-password", pocketBaseUrl)
-
-	resp, err := http.Post(authURL, "application/json", bytes.NewBuffer(authDataJson))
-	if err != nil {
-		return fmt.Errorf("не удалось отправить запрос на авторизацию: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("авторизация не удалась, код %d, ответ: %s", resp.StatusCode, string(body))
-	}
-
-	// getting jwt
-	body, _ := io.ReadAll(resp.Body)
-	var authResponse map[string]interface{}
-	if err := json.Unmarshal(body, &authResponse); err != nil {
-		return fmt.Errorf("ошибка разбора ответа: %v, ответ: %s", err, string(body))
-	}
-
-	token, ok := authResponse["token"].(string)
-	if !ok || token == "" {
-		return fmt.Errorf("не удалось получить токен из ответа: %s", string(body))
-	}
-
-	authToken = token
-	log.Println("PocketBase: Авторизация прошла успешно. Получен токен от PocketBase.")
-	return nil
-}
-
 func getOrCreateUser(tgUserID int, tgUsername string) (string, error) {
 	// Search in pocketbase
-	searchURL := fmt.Sprintf("%s/api/collections/users/records?filter=tgid=%d", pocketBaseUrl, tgUserID)
-	resp, err := sendAuthorizedRequest("GET", searchURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("ошибка при отправке запроса на поиск пользователя: %v", err)
+	user, err := pb.FindUserByTGID(tgUserID)
+	if err == nil {
+		// Пользователь найден, возвращаем его ID
+		return user.ID, nil
 	}
-
-	var searchResult map[string]interface{}
-	if err := json.Unmarshal(resp, &searchResult); err != nil {
-		return "", fmt.Errorf("ошибка разбора ответа: %v, ответ: %s", err, string(resp))
-	}
-
-	// Check search results
-	if items, ok := searchResult["items"].([]interface{}); ok && len(items) > 0 {
-		if user, ok := items[0].(map[string]interface{}); ok {
-			if userID, ok := user["id"]; ok {
-				if idStr, ok := userID.(string); ok && idStr != "" {
-					// Пользователь найден, возвращаем его ID
-					return idStr, nil
-				}
-			}
-		}
+	if !pbclient.IsNotFound(err) {
+		return "", fmt.Errorf("ошибка при поиске пользователя: %w", err)
 	}
 
 	// New user creation
@@ -86,276 +34,409 @@ func getOrCreateUser(tgUserID int, tgUsername string) (string, error) {
 		"face_replace_count": 0,
 		"coins":              200,
 	}
-	userDataJson, _ := json.Marshal(userData)
 
-	createUserURL := fmt.Sprintf("%s/api/collections/users/records", pocketBaseUrl)
-	createResp, err := sendAuthorizedRequest("POST", createUserURL, userDataJson)
+	var createdUser pbclient.User
+	err = pb.Create(pbclient.UsersCollection, userData, &createdUser)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при отправке запроса на создание пользователя: %v", err)
-	}
-
-	var createdUser map[string]interface{}
-	if err := json.Unmarshal(createResp, &createdUser); err != nil {
-		return "", fmt.Errorf("ошибка разбора ответа на создание пользователя: %v, ответ: %s", err, string(createResp))
+		return "", fmt.Errorf("ошибка при создании пользователя: %w", err)
 	}
 
 	// User creation recheck
-	if userID, ok := createdUser["id"].(string); ok && userID != "" {
-		return userID, nil
+	if createdUser.ID == "" {
+		return "", fmt.Errorf("не удалось получить ID созданного пользователя")
 	}
 
-	return "", fmt.Errorf("не удалось получить ID созданного пользователя из ответа: %s", string(createResp))
+	return createdUser.ID, nil
+}
+
+// Проверка размера скачанного файла
+// needed for testing. will be removed.
+func checkFileSize(path, name string) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("не удалось получить информацию о %s: %v", name, err)
+	}
+	if fileInfo.Size() > 500*1024*1024 {
+		return fmt.Errorf("размер %s превышает 500 МБ", name)
+	}
+	return nil
 }
 
 // Face replacement job creation
-func createFaceJob(bot *tgbotapi.BotAPI, userID, inputMediaFileID, inputFaceFileID string) (string, error) {
+func createFaceJob(bot *tgbotapi.BotAPI, userID, inputMediaFileID, inputFaceFileID string, statusMessage tgbotapi.Message) (*pbclient.Job, error) {
+	// баланс проверяется до скачивания файлов
+	if err := checkBalance(userID, pbclient.FaceJobsCollection); err != nil {
+		return nil, err
+	}
+
 	// file download
 	inputMediaPath, err := getTelegramFile(bot, inputMediaFileID)
 	if err != nil {
-		return "", fmt.Errorf("не удалось скачать видеофайл: %v", err)
+		return nil, fmt.Errorf("не удалось скачать видеофайл: %v", err)
 	}
 
 	inputFacePath, err := getTelegramFile(bot, inputFaceFileID)
 	if err != nil {
-		return "", fmt.Errorf("не удалось скачать файл лица: %v", err)
+		return nil, fmt.Errorf("не удалось скачать файл лица: %v", err)
 	}
 
 	// file checker
-	inputMediaFile, err := os.Open(inputMediaPath)
-	if err != nil {
-		return "", fmt.Errorf("не удалось открыть видеофайл: %v", err)
+	if err := checkFileSize(inputMediaPath, "видеофайла"); err != nil {
+		return nil, err
 	}
-	defer inputMediaFile.Close()
-
-	inputFaceFile, err := os.Open(inputFacePath)
-	if err != nil {
-		return "", fmt.Errorf("не удалось открыть файл лица: %v", err)
+	if err := checkFileSize(inputFacePath, "файла лица"); err != nil {
+		return nil, err
 	}
-	defer inputFaceFile.Close()
 
-	// Check size
-	// needed for testing. will be removed.
-	fileInfo, err := inputMediaFile.Stat()
-	if err != nil {
-		return "", fmt.Errorf("не удалось получить информацию о видеофайле: %v", err)
+	// metadata
+	fields := map[string]string{
+		"owner":  userID,
+		"status": "pending", // в очередь задача попадает после оплаты
 	}
-	if fileInfo.Size() > 500*1024*1024 {
-		return "", fmt.Errorf("размер видео превышает 500 МБ")
+	setStatusMessage(fields, statusMessage)
+	files := []pbclient.File{
+		{Field: "input_media", Path: inputMediaPath},
+		{Field: "input_face", Path: inputFacePath},
 	}
 
-	faceFileInfo, err := inputFaceFile.Stat()
+	var job pbclient.FaceJob
+	err = pb.CreateWithFiles(pbclient.FaceJobsCollection, fields, files, &job)
 	if err != nil {
-		return "", fmt.Errorf("не удалось получить информацию о файле лица: %v", err)
+		return nil, fmt.Errorf("ошибка создания face job: %w", err)
 	}
-	if faceFileInfo.Size() > 500*1024*1024 {
-		return "", fmt.Errorf("размер файла лица превышает 500 МБ")
+	if job.ID == "" {
+		return nil, fmt.Errorf("не удалось получить ID новой задачи")
 	}
 
-	// body for multipart/form-data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	log.Printf("Задача Face Job успешно создана с ID: %s", job.ID)
+	return payForJob(userID, pbclient.FaceJobsCollection, job.ID)
+}
 
-	// metadata
-	_ = writer.WriteField("owner", userID)
-	_ = writer.WriteField("status", "queued") // Статус задачи по умолчанию
+// Функция для создания Circle Job
+func createCircleJob(bot *tgbotapi.BotAPI, userID string, input *videoInput, statusMessage tgbotapi.Message) (*pbclient.Job, error) {
+	// баланс проверяется до скачивания файла
+	if err := checkBalance(userID, pbclient.CircleJobsCollection); err != nil {
+		return nil, err
+	}
 
-	// Добавляем файлы в request
-	mediaPart, err := writer.CreateFormFile("input_media", fileInfo.Name())
+	// file download
+	inputMediaPath, err := getTelegramFile(bot, input.FileID)
 	if err != nil {
-		return "", fmt.Errorf("не удалось создать часть для видеофайла: %v", err)
+		return nil, fmt.Errorf("не удалось скачать видеофайл: %v", err)
 	}
-	_, err = io.Copy(mediaPart, inputMediaFile)
-	if err != nil {
-		return "", fmt.Errorf("не удалось загрузить видеофайл: %v", err)
+
+	// file check
+	if err := checkFileSize(inputMediaPath, "видеофайла"); err != nil {
+		return nil, err
 	}
 
-	facePart, err := writer.CreateFormFile("input_face", faceFileInfo.Name())
-	if err != nil {
-		return "", fmt.Errorf("не удалось создать часть для файла лица: %v", err)
+	// Добавляем метаданные (например, владелец и статус)
+	fields := map[string]string{
+		"owner":      userID,
+		"status":     "pending", // в очередь задача попадает после оплаты
+		"input_kind": input.Kind,
+		"input_mime": input.MimeType,
 	}
-	_, err = io.Copy(facePart, inputFaceFile)
+	setStatusMessage(fields, statusMessage)
+	files := []pbclient.File{{Field: "input_media", Path: inputMediaPath}}
+
+	var job pbclient.CircleJob
+	err = pb.CreateWithFiles(pbclient.CircleJobsCollection, fields, files, &job)
 	if err != nil {
-		return "", fmt.Errorf("не удалось загрузить файл лица: %v", err)
+		return nil, fmt.Errorf("ошибка создания circle job: %w", err)
 	}
+	if job.ID == "" {
+		return nil, fmt.Errorf("не удалось получить ID новой задачи")
+	}
+
+	log.Printf("Задача Circle Job успешно создана с ID: %s", job.ID)
+	return payForJob(userID, pbclient.CircleJobsCollection, job.ID)
+}
 
-	// Закрываем writer, чтобы завершить формирование multipart
-	err = writer.Close()
+// insufficientCoinsError - у пользователя не хватает монет на задачу
+type insufficientCoinsError struct {
+	Price   int
+	Balance int
+}
+
+func (e *insufficientCoinsError) Error() string {
+	return fmt.Sprintf("недостаточно монет: цена %d, баланс %d", e.Price, e.Balance)
+}
+
+// Проверка, что у пользователя хватает монет на задачу коллекции.
+// Окончательно баланс проверяет pocketbase при оплате (payForJob).
+func checkBalance(userID, collection string) error {
+	prices, err := pb.JobPrices()
 	if err != nil {
-		return "", fmt.Errorf("не удалось завершить формирование multipart: %v", err)
+		return err
 	}
-
-	// Формируем запрос
-	createJobURL := fmt.Sprintf("%s/api/collections/face_jobs/records", pocketBaseUrl)
-	req, err := http.NewRequest("POST", createJobURL, body)
+	user, err := pb.GetUser(userID)
 	if err != nil {
-		return "", fmt.Errorf("не удалось создать HTTP-запрос для создания задачи: %v", err)
+		return fmt.Errorf("ошибка при запросе пользователя: %w", err)
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken)) // Добавляем токен авторизации
-
-	// Выполняем запрос
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("ошибка выполнения запроса на создание face job: %v", err)
+	price := prices[collection]
+	if user.Coins < price {
+		return &insufficientCoinsError{Price: price, Balance: user.Coins}
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	// Обрабатываем ответ
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("ошибка создания face job, код: %d, ответ: %s", resp.StatusCode, string(respBody))
+// Оплата созданной задачи через /api/jobs/.../reserve: монеты списываются, задача попадает в очередь.
+// Неоплаченная задача удаляется вместе с файлами.
+func payForJob(userID, collection, jobID string) (*pbclient.Job, error) {
+	var job pbclient.Job
+	err := pb.JobAction(collection, jobID, "reserve", map[string]string{"owner": userID}, &job)
+	if err == nil {
+		log.Printf("Задача %s оплачена: %d монет", jobID, job.Price)
+		return &job, nil
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("ошибка парсинга ответа JSON: %v", err)
+	if deleteErr := pb.Delete(collection, jobID); deleteErr != nil {
+		log.Printf("Не удалось удалить неоплаченную задачу %s: %v", jobID, deleteErr)
 	}
 
-	jobID, ok := result["id"].(string)
-	if !ok || jobID == "" {
-		return "", fmt.Errorf("не удалось получить ID новой задачи, ответ: %s", string(respBody))
+	if pbclient.StatusCode(err) == http.StatusPaymentRequired {
+		// баланс изменился после checkBalance
+		if balanceErr := checkBalance(userID, collection); balanceErr != nil {
+			return nil, balanceErr
+		}
 	}
+	return nil, fmt.Errorf("ошибка оплаты задачи %s: %w", jobID, err)
+}
 
-	log.Printf("Задача Circle Job успешно создана с ID: %s", jobID)
-	return jobID, nil
+// Сообщение о статусе задачи, которое бот редактирует по событиям realtime (см. watchJobs)
+func setStatusMessage(fields map[string]string, statusMessage tgbotapi.Message) {
+	if statusMessage.MessageID == 0 {
+		return
+	}
+	fields["status_chat_id"] = strconv.FormatInt(statusMessage.Chat.ID, 10)
+	fields["status_message_id"] = strconv.Itoa(statusMessage.MessageID)
 }
 
-// Функция для создания Circle Job
-func createCircleJob(bot *tgbotapi.BotAPI, userID, inputMediaFileID string) (string, error) {
-	// file download
-	inputMediaPath, err := getTelegramFile(bot, inputMediaFileID)
+func getUserInfo(tgUserID int) (*pbclient.User, error) {
+	// Поиск пользователя в PocketBase по tgid
+	user, err := pb.FindUserByTGID(tgUserID)
 	if err != nil {
-		return "", fmt.Errorf("не удалось скачать видеофайл: %v", err)
+		return nil, fmt.Errorf("ошибка при запросе пользователя: %w", err)
 	}
+	return user, nil
+}
 
-	// file check
-	inputMediaFile, err := os.Open(inputMediaPath)
+// сколько последних активных задач каждого типа показывает /status, остальные доступны в /history
+const statusJobsLimit = 5
+
+// сколько неудавшиеся задачи остаются в /status с кнопкой повтора
+const statusFailedWindow = 24 * time.Hour
+
+// Последние limit активных задач пользователя (в очереди, в работе, ожидают оплаты
+// и неудавшиеся за statusFailedWindow) и их общее число
+func getActiveJobs(userID, collection string, limit int) ([]pbclient.Job, int, error) {
+	filter, err := pbclient.Filter(
+		"owner={:owner} && (status={:pending} || status={:queued} || status={:processing} || "+
+			"((status={:failed} || status={:dead}) && failed_at>={:since}))",
+		pbclient.Params{
+			"owner":      userID,
+			"pending":    "pending",
+			"queued":     "queued",
+			"processing": "processing",
+			"failed":     "failed",
+			"dead":       "dead",
+			"since":      time.Now().Add(-statusFailedWindow),
+		})
 	if err != nil {
-		return "", fmt.Errorf("не удалось открыть видеофайл: %v", err)
+		return nil, 0, err
 	}
-	defer inputMediaFile.Close()
 
-	// Check size
-	// needed for testing. will be removed.
-	fileInfo, err := inputMediaFile.Stat()
+	result, err := pbclient.List[pbclient.Job](pb, collection, pbclient.ListQuery{
+		Filter:  filter,
+		Sort:    "-created",
+		PerPage: limit,
+	})
 	if err != nil {
-		return "", fmt.Errorf("не удалось получить информацию о видеофайле: %v", err)
-	}
-	if fileInfo.Size() > 500*1024*1024 {
-		return "", fmt.Errorf("размер видео превышает 500 МБ")
+		return nil, 0, fmt.Errorf("ошибка при запросе задач: %w", err)
 	}
 
-	// Создаем body для multipart/form-data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	return result.Items, result.TotalItems, nil
+}
 
-	// Добавляем метаданные (например, владелец и статус)
-	_ = writer.WriteField("owner", userID)
-	_ = writer.WriteField("status", "queued") // Статус задачи по умолчанию
+// коллекции задач, в которых ищется задача по ID из команд
+var jobCollections = []string{pbclient.CircleJobsCollection, pbclient.FaceJobsCollection}
 
-	// Добавляем файлы в request
-	mediaPart, err := writer.CreateFormFile("input_media", fileInfo.Name())
-	if err != nil {
-		return "", fmt.Errorf("не удалось создать часть для видеофайла: %v", err)
-	}
-	_, err = io.Copy(mediaPart, inputMediaFile)
-	if err != nil {
-		return "", fmt.Errorf("не удалось загрузить видеофайл: %v", err)
+// Действие пользователя над своей задачей через /api/jobs/.../<action> (pocketbase/pb_hooks).
+// Если коллекция не указана, задача ищется во всех. Чужая задача не находится.
+// Возвращает коллекцию, в которой нашлась задача.
+func ownJobAction(userID, collection, jobID, action string, out interface{}) (string, error) {
+	collections := jobCollections
+	if collection != "" {
+		collections = []string{collection}
 	}
 
-	// Закрываем writer, чтобы завершить формирование multipart
-	err = writer.Close()
-	if err != nil {
-		return "", fmt.Errorf("не удалось завершить формирование multipart: %v", err)
+	var err error
+	for _, collection = range collections {
+		err = pb.JobAction(collection, jobID, action, map[string]string{"owner": userID}, out)
+		if !pbclient.IsNotFound(err) {
+			break
+		}
 	}
+	return collection, err
+}
 
-	// Формируем запрос
-	createJobURL := fmt.Sprintf("%s/api/collections/circle_jobs/records", pocketBaseUrl)
-	req, err := http.NewRequest("POST", createJobURL, body)
+// Отмена задачи пользователя
+func cancelJob(userID, collection, jobID string) error {
+	_, err := ownJobAction(userID, collection, jobID, "cancel", nil)
 	if err != nil {
-		return "", fmt.Errorf("не удалось создать HTTP-запрос для создания задачи: %v", err)
+		return fmt.Errorf("ошибка отмены задачи %s: %w", jobID, err)
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken)) // Добавляем токен авторизации
+	log.Printf("Задача %s отменена пользователем %s", jobID, userID)
+	return nil
+}
 
-	// Выполняем запрос
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// Повтор задачи пользователя в статусе failed, dead или cancelled.
+// Возвращает место задачи в очереди, 0 - если его не удалось посчитать.
+func retryJob(userID, collection, jobID string) (int, error) {
+	var job pbclient.Job
+	collection, err := ownJobAction(userID, collection, jobID, "retry", &job)
 	if err != nil {
-		return "", fmt.Errorf("ошибка выполнения запроса на создание circle job: %v", err)
+		return 0, fmt.Errorf("ошибка повтора задачи %s: %w", jobID, err)
 	}
-	defer resp.Body.Close()
+	log.Printf("Задача %s возвращена в очередь пользователем %s", jobID, userID)
 
-	// Обрабатываем ответ
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("ошибка создания face job, код: %d, ответ: %s", resp.StatusCode, string(respBody))
+	// задача уже в очереди, ошибка подсчета места не должна выглядеть как неудачный повтор
+	position, err := queuePosition(collection, &job)
+	if err != nil {
+		log.Printf("Не удалось определить место задачи %s в очереди: %v", jobID, err)
+		return 0, nil
 	}
+	return position, nil
+}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("ошибка парсинга ответа JSON: %v", err)
+// Место задачи в очереди: воркеры берут задачи в порядке создания
+func queuePosition(collection string, job *pbclient.Job) (int, error) {
+	filter, err := pbclient.Filter("status={:status} && created<{:created}", pbclient.Params{
+		"status":  "queued",
+		"created": job.Created,
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	jobID, ok := result["id"].(string)
-	if !ok || jobID == "" {
-		return "", fmt.Errorf("не удалось получить ID новой задачи, ответ: %s", string(respBody))
+	ahead, err := pbclient.List[pbclient.Job](pb, collection, pbclient.ListQuery{Filter: filter, PerPage: 1})
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при подсчете очереди: %w", err)
 	}
-
-	log.Printf("Задача Circle Job успешно создана с ID: %s", jobID)
-	return jobID, nil
+	return ahead.TotalItems + 1, nil
 }
 
-func getUserInfo(tgUserID int) (map[string]interface{}, error) {
-	// Поиск пользователя в PocketBase по tgid
-	searchURL := fmt.Sprintf("%s/api/collections/users/records?filter=tgid=%d", pocketBaseUrl, tgUserID)
-	resp, err := sendAuthorizedRequest("GET", searchURL, nil)
+// сколько последних выполненных задач учитывается в оценке времени обработки
+const etaSampleSize = 20
+
+// Среднее время обработки (от захвата до завершения) последних выполненных задач коллекции.
+// 0, если выполненных задач еще нет.
+func averageJobDuration(collection string) (time.Duration, error) {
+	filter, err := pbclient.Filter("status={:status} && completed_at!='' && claimed_at!=''", pbclient.Params{
+		"status": "completed",
+	})
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при запросе пользователя: %v", err)
+		return 0, err
 	}
 
-	var searchResult map[string]interface{}
-	if err := json.Unmarshal(resp, &searchResult); err != nil {
-		return nil, fmt.Errorf("ошибка разбора ответа при получении пользователя: %v", err)
+	recent, err := pbclient.List[pbclient.Job](pb, collection, pbclient.ListQuery{
+		Filter:  filter,
+		Sort:    "-completed_at",
+		PerPage: etaSampleSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при запросе выполненных задач: %w", err)
 	}
 
-	if items, ok := searchResult["items"].([]interface{}); ok && len(items) > 0 {
-		if user, ok := items[0].(map[string]interface{}); ok {
-			return user, nil
+	var total time.Duration
+	var count int
+	for _, job := range recent.Items {
+		duration := job.CompletedAt.Sub(job.ClaimedAt.Time)
+		if duration <= 0 {
+			continue
 		}
+		total += duration
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return total / time.Duration(count), nil
+}
+
+// Место задачи в очереди и примерное время до ее завершения: задачи впереди и она сама
+// обрабатываются по очереди со средней длительностью. eta равно 0, если оценить его нельзя.
+func queueEstimate(collection string, job *pbclient.Job) (position int, eta time.Duration, err error) {
+	position, err = queuePosition(collection, job)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return nil, fmt.Errorf("пользователь с Telegram ID %d не найден", tgUserID)
+	// место в очереди известно и без оценки времени
+	average := averageDuration(collection)
+	return position, average * time.Duration(position), nil
 }
 
-func getActiveJobs(userID, collection string) ([]map[string]interface{}, error) {
-	filter := fmt.Sprintf("owner=\"%s\" && status!=\"completed\"", userID)
-	encodedFilter := url.QueryEscape(filter) // Кодируем фильтр для передачи в URL
+// historyEntry - задача из истории пользователя и ее коллекция
+type historyEntry struct {
+	Collection string
+	Job        pbclient.Job
+}
 
-	searchURL := fmt.Sprintf("%s/api/collections/%s/records?filter=%s", pocketBaseUrl, collection, encodedFilter)
+// сколько последних задач доступно в /history: pocketbase отдает не больше 500 записей за запрос
+const historyMaxJobs = 500
 
-	resp, err := sendAuthorizedRequest("GET", searchURL, nil)
+// Страница истории задач пользователя из всех коллекций, новые первыми.
+// Возвращает задачи страницы и общее число задач в истории (не больше historyMaxJobs).
+func getJobHistory(userID string, page, pageSize int) ([]historyEntry, int, error) {
+	filter, err := pbclient.Filter("owner={:owner}", pbclient.Params{"owner": userID})
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при запросе задач: %v", err)
+		return nil, 0, err
+	}
+
+	// страница общей истории - из первых page*pageSize задач каждой коллекции
+	limit := min(page*pageSize, historyMaxJobs)
+	var entries []historyEntry
+	total := 0
+	for _, collection := range jobCollections {
+		jobs, err := pbclient.List[pbclient.Job](pb, collection, pbclient.ListQuery{
+			Filter:  filter,
+			Sort:    "-created",
+			PerPage: limit,
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("ошибка при запросе истории задач: %w", err)
+		}
+		for _, job := range jobs.Items {
+			entries = append(entries, historyEntry{Collection: collection, Job: job})
+		}
+		total += jobs.TotalItems
 	}
 
-	var searchResult map[string]interface{}
-	if err := json.Unmarshal(resp, &searchResult); err != nil {
-		return nil, fmt.Errorf("ошибка разбора ответа при получении задач: %v, ответ: %s", err, string(resp))
-	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Job.Created.After(entries[j].Job.Created.Time)
+	})
 
-	if items, ok := searchResult["items"].([]interface{}); ok {
-		jobs := make([]map[string]interface{}, len(items))
-		for i, item := range items {
-			if job, ok := item.(map[string]interface{}); ok {
-				jobs[i] = job
-			}
-		}
-		return jobs, nil
+	start := min((page-1)*pageSize, len(entries))
+	end := min(start+pageSize, len(entries))
+	return entries[start:end], min(total, historyMaxJobs), nil
+}
+
+// Задача пользователя по ID; чужая задача не находится
+func getOwnJob(userID, collection, jobID string) (*pbclient.Job, error) {
+	if !slices.Contains(jobCollections, collection) {
+		return nil, fmt.Errorf("неизвестная коллекция задач %s: %w", collection, pbclient.ErrNotFound)
 	}
 
-	return nil, nil
+	job, err := pbclient.Get[pbclient.Job](pb, collection, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения задачи %s: %w", jobID, err)
+	}
+	if job.Owner != userID {
+		return nil, fmt.Errorf("задача %s принадлежит другому пользователю: %w", jobID, pbclient.ErrNotFound)
+	}
+	return job, nil
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// сколько необработанных обновлений одного пользователя держится в очереди, остальные отбрасываются
+const maxPendingUpdates = 50
+
+// dispatcher - параллельная обработка обновлений: обновления разных пользователей обрабатываются
+// одновременно (не больше workers), обновления одного пользователя - по очереди в порядке поступления.
+// Долгая загрузка видео одного пользователя не задерживает остальных.
+type dispatcher struct {
+	handle func(update tgbotapi.Update)
+	slots  chan struct{}
+
+	mu sync.Mutex
+	// очередь пользователя, который сейчас обрабатывается; нет ключа - обработчик не запущен
+	pending map[int64][]tgbotapi.Update
+	wg      sync.WaitGroup
+}
+
+func newDispatcher(workers int, handle func(update tgbotapi.Update)) *dispatcher {
+	return &dispatcher{
+		handle:  handle,
+		slots:   make(chan struct{}, workers),
+		pending: make(map[int64][]tgbotapi.Update),
+	}
+}
+
+// Передача обновления в обработку без ожидания
+func (d *dispatcher) Dispatch(update tgbotapi.Update) {
+	userID := updateUserID(update)
+
+	d.mu.Lock()
+	queue, running := d.pending[userID]
+	if running {
+		if len(queue) >= maxPendingUpdates {
+			d.mu.Unlock()
+			log.Printf("Очередь обновлений пользователя %d переполнена, обновление %d отброшено", userID, update.UpdateID)
+			return
+		}
+		d.pending[userID] = append(queue, update)
+		d.mu.Unlock()
+		return
+	}
+	d.pending[userID] = nil
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go d.run(userID, update)
+}
+
+// Обработка обновлений пользователя, пока его очередь не опустеет
+func (d *dispatcher) run(userID int64, update tgbotapi.Update) {
+	defer d.wg.Done()
+
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	for {
+		d.handle(update)
+
+		d.mu.Lock()
+		queue := d.pending[userID]
+		if len(queue) == 0 {
+			delete(d.pending, userID)
+			d.mu.Unlock()
+			return
+		}
+		update = queue[0]
+		d.pending[userID] = queue[1:]
+		d.mu.Unlock()
+	}
+}
+
+// Ожидание обработки уже принятых обновлений
+func (d *dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Отправитель обновления: порядок соблюдается для каждого пользователя
+func updateUserID(update tgbotapi.Update) int64 {
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
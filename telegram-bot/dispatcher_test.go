@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func userUpdate(updateID int, userID int64) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{From: &tgbotapi.User{ID: userID}},
+	}
+}
+
+// Ожидание условия с таймаутом
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Обновления одного пользователя обрабатываются по порядку и не пересекаются
+func TestDispatcherUserOrder(t *testing.T) {
+	const updates = 30
+
+	var (
+		mu      sync.Mutex
+		active  = make(map[int64]int)
+		handled = make(map[int64][]int)
+	)
+	d := newDispatcher(4, func(update tgbotapi.Update) {
+		userID := update.Message.From.ID
+		mu.Lock()
+		active[userID]++
+		if active[userID] > 1 {
+			t.Errorf("обновления пользователя %d обрабатываются одновременно", userID)
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		active[userID]--
+		handled[userID] = append(handled[userID], update.UpdateID)
+		mu.Unlock()
+	})
+
+	for i := 0; i < updates; i++ {
+		d.Dispatch(userUpdate(i, 1))
+		d.Dispatch(userUpdate(i, 2))
+	}
+	d.Wait()
+
+	for _, userID := range []int64{1, 2} {
+		if len(handled[userID]) != updates {
+			t.Fatalf("пользователь %d: обработано %d из %d", userID, len(handled[userID]), updates)
+		}
+		for i, updateID := range handled[userID] {
+			if updateID != i {
+				t.Fatalf("пользователь %d: порядок нарушен: %v", userID, handled[userID])
+			}
+		}
+	}
+}
+
+// Разные пользователи обрабатываются одновременно, но не больше workers
+func TestDispatcherWorkersLimit(t *testing.T) {
+	const (
+		workers = 3
+		users   = 6
+	)
+
+	var (
+		mu      sync.Mutex
+		running int
+		peak    int
+		handled int
+	)
+	release := make(chan struct{})
+	d := newDispatcher(workers, func(update tgbotapi.Update) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		handled++
+		mu.Unlock()
+	})
+
+	for i := 0; i < users; i++ {
+		d.Dispatch(userUpdate(i, int64(i+1)))
+	}
+
+	runningNow := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return running
+	}
+	eventually(t, "одновременная обработка", func() bool { return runningNow() == workers })
+	// свободных мест нет: остальные пользователи ждут
+	time.Sleep(50 * time.Millisecond)
+	if n := runningNow(); n != workers {
+		t.Errorf("одновременно обрабатывается %d, ожидалось %d", n, workers)
+	}
+
+	close(release)
+	d.Wait()
+	if peak != workers || handled != users {
+		t.Errorf("максимум одновременно %d, обработано %d; ожидалось %d и %d", peak, handled, workers, users)
+	}
+}
+
+// Пока обновление пользователя обрабатывается, в очереди держится не больше maxPendingUpdates
+func TestDispatcherDropsOverflow(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		handled []int
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	d := newDispatcher(1, func(update tgbotapi.Update) {
+		if update.UpdateID == 0 {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		handled = append(handled, update.UpdateID)
+		mu.Unlock()
+	})
+
+	d.Dispatch(userUpdate(0, 1))
+	<-started
+	for i := 1; i <= maxPendingUpdates+10; i++ {
+		d.Dispatch(userUpdate(i, 1))
+	}
+	close(release)
+	d.Wait()
+
+	if len(handled) != maxPendingUpdates+1 {
+		t.Fatalf("обработано %d обновлений, ожидалось %d", len(handled), maxPendingUpdates+1)
+	}
+	for i, updateID := range handled {
+		if updateID != i {
+			t.Fatalf("обработаны не первые обновления: %v", handled)
+		}
+	}
+
+	// после разбора очереди обновления пользователя снова принимаются
+	d.Dispatch(userUpdate(100, 1))
+	d.Wait()
+	if handled[len(handled)-1] != 100 {
+		t.Error("новое обновление после переполнения не обработано")
+	}
+}
@@ -2,5 +2,10 @@ module faceswaper
 
 go 1.23.3
 
-require github.com/joho/godotenv v1.5.1
+require github.com/joho/godotenv v1.5.1 // indirect
+
 require github.com/OvyFlash/telegram-bot-api v0.0.0-20241107191146-851f2334eccf
+
+require pbclient v0.0.0
+
+replace pbclient => ../pbclient
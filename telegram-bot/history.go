@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"pbclient"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// задач на странице /history: страница заведомо укладывается в 4096 символов сообщения Telegram
+const historyPageSize = 5
+
+// Название типа задачи по коллекции
+func jobKindLabel(collection string) string {
+	switch collection {
+	case pbclient.CircleJobsCollection:
+		return "🌀 Кружок"
+	case pbclient.FaceJobsCollection:
+		return "💼 Замена лица"
+	default:
+		return collection
+	}
+}
+
+// Текст и кнопки страницы истории: скачивание выполненных задач и переход между страницами
+func historyPage(userID string, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	entries, total, err := getJobHistory(userID, page, historyPageSize)
+	if err != nil {
+		return "", nil, err
+	}
+	if total == 0 {
+		return "У вас еще нет задач.", nil, nil
+	}
+
+	pages := (total + historyPageSize - 1) / historyPageSize
+	text := fmt.Sprintf("🗂 История задач, страница %d из %d:\n\n", page, pages)
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, entry := range entries {
+		job := entry.Job
+		text += fmt.Sprintf(
+			"%s %s\n"+
+				"   Статус: %s\n"+
+				"   Создана: %s\n\n",
+			jobKindLabel(entry.Collection),
+			job.ID,
+			statusLabel(job.Status),
+			job.Created,
+		)
+		if job.Status == "completed" && job.OutputMedia != "" {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("⬇️ Скачать "+job.ID, jobCallbackData("download", entry.Collection, job.ID)),
+			))
+		}
+	}
+
+	var navigation []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", historyCallbackData(page-1)))
+	}
+	if page < pages {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("Вперед ▶️", historyCallbackData(page+1)))
+	}
+	if len(navigation) > 0 {
+		buttons = append(buttons, navigation)
+	}
+
+	if len(buttons) == 0 {
+		return text, nil, nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	return text, &markup, nil
+}
+
+// данные inline-кнопки страницы истории: "history:<page>"
+func historyCallbackData(page int) string {
+	return fmt.Sprintf("history:%d", page)
+}
+
+// /history - первая страница истории задач
+func handleHistoryCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, pbUserID string) {
+	text, markup, err := historyPage(pbUserID, 1)
+	if err != nil {
+		log.Printf("Не удалось получить историю задач: %v", err)
+		text = "Произошла ошибка при получении истории задач. Попробуйте позже."
+	}
+
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	bot.Send(msg)
+}
+
+// Переход на другую страницу истории: сообщение редактируется на месте
+func handleHistoryPage(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, pbUserID, pageData string) {
+	page, err := strconv.Atoi(pageData)
+	if err != nil || page < 1 || query.Message == nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, "Неизвестная команда."))
+		return
+	}
+
+	text, markup, err := historyPage(pbUserID, page)
+	if err != nil {
+		log.Printf("Не удалось получить историю задач: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Произошла ошибка, попробуйте позже."))
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ReplyMarkup = markup
+	_, err = bot.Request(edit)
+	if err != nil {
+		log.Printf("Ошибка обновления страницы истории: %v", err)
+	}
+	bot.Request(tgbotapi.NewCallback(query.ID, ""))
+}
+
+// у задачи нет результата, который можно отправить повторно
+var errNoResult = errors.New("результат задачи недоступен")
+
+// Повторная отправка результата выполненной задачи из output_media
+func sendJobResult(bot *tgbotapi.BotAPI, chatID int64, pbUserID, collection, jobID string) error {
+	job, err := getOwnJob(pbUserID, collection, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status != "completed" || job.OutputMedia == "" {
+		return fmt.Errorf("задача %s в статусе %s: %w", jobID, job.Status, errNoResult)
+	}
+
+	resp, err := pb.HTTPClient.Get(pb.FileURL(collection, job.ID, job.OutputMedia))
+	if err != nil {
+		return fmt.Errorf("ошибка скачивания результата: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ошибка скачивания результата: статус %d", resp.StatusCode)
+	}
+
+	// результат отправляется так же, как его отправляет job-manager
+	file := tgbotapi.FileReader{Name: job.OutputMedia, Reader: resp.Body}
+	var result tgbotapi.Chattable
+	if collection == pbclient.CircleJobsCollection {
+		result = tgbotapi.NewVideoNote(chatID, 0, file)
+	} else {
+		result = tgbotapi.NewVideo(chatID, file)
+	}
+
+	_, err = bot.Send(result)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки результата: %v", err)
+	}
+	return nil
+}
+
+// Сообщение об ошибке повторной отправки результата
+func downloadResultText(jobID string, err error) string {
+	if pbclient.IsNotFound(err) {
+		return fmt.Sprintf("Задача %s не найдена.", jobID)
+	}
+	if errors.Is(err, errNoResult) {
+		return fmt.Sprintf("У задачи %s нет готового результата.", jobID)
+	}
+	log.Printf("Не удалось отправить результат задачи %s: %v", jobID, err)
+	return "Не удалось отправить результат. Попробуйте позже."
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"pbclient"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// длина полосы прогресса в сообщении о статусе
+const progressBarWidth = 10
+
+// Текст сообщения о статусе задачи; для задачи в очереди - с местом и оценкой ожидания
+func jobStatusText(collection string, job *pbclient.Job) string {
+	text := fmt.Sprintf("🎬 Задача %s\nСтатус: %s", job.ID, statusLabel(job.Status))
+	switch job.Status {
+	case "queued":
+		position, eta, err := queueEstimate(collection, job)
+		if err != nil {
+			log.Printf("Не удалось определить место задачи %s в очереди: %v", job.ID, err)
+			break
+		}
+		text += "\n" + queueText(position, eta)
+	case "processing":
+		text += fmt.Sprintf("\n%s %d%%", progressBar(job.Progress), job.Progress)
+	}
+	return text
+}
+
+// Место в очереди и примерное время ожидания, если его удалось оценить
+func queueText(position int, eta time.Duration) string {
+	text := fmt.Sprintf("Место в очереди: %d", position)
+	if eta > 0 {
+		text += ", ожидание: " + formatETA(eta)
+	}
+	return text
+}
+
+// Примерное время ожидания с точностью до минут
+func formatETA(eta time.Duration) string {
+	if eta < time.Minute {
+		return "меньше минуты"
+	}
+	minutes := int(eta.Round(time.Minute).Minutes())
+	if minutes < 60 {
+		return fmt.Sprintf("≈ %d мин", minutes)
+	}
+	return fmt.Sprintf("≈ %d ч %d мин", minutes/60, minutes%60)
+}
+
+// Полоса прогресса из progressBarWidth символов
+func progressBar(percent int) string {
+	filled := max(0, min(percent, 100)) * progressBarWidth / 100
+	return strings.Repeat("▓", filled) + strings.Repeat("░", progressBarWidth-filled)
+}
+
+// Замена текста сообщения о статусе задачи
+func editStatusMessage(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string) error {
+	_, err := bot.Request(tgbotapi.NewEditMessageText(chatID, messageID, text))
+	return err
+}
+
+// Обновление сообщений о статусе задач по событиям realtime pocketbase:
+// job-manager пишет в задачу статус и прогресс, бот редактирует сообщение из status_message_id.
+func watchJobs(ctx context.Context, bot *tgbotapi.BotAPI) {
+	// последний отправленный текст по ID задачи: Telegram отклоняет правку без изменений
+	sent := make(map[string]string)
+	// updated последней задачи из событий: изменения позже него могли прийти без подписки
+	since := pbclient.NewDateTime(time.Now())
+
+	update := func(collection string, job *pbclient.Job) {
+		if job.Updated.After(since.Time) {
+			since = job.Updated
+		}
+		if job.StatusMessageID == 0 {
+			return
+		}
+
+		text := jobStatusText(collection, job)
+		if sent[job.ID] == text {
+			return
+		}
+		err := editStatusMessage(bot, job.StatusChatID, job.StatusMessageID, text)
+		if err != nil {
+			log.Printf("Ошибка обновления сообщения о статусе задачи %s: %v", job.ID, err)
+		}
+
+		switch job.Status {
+		case "completed", "failed", "dead", "cancelled":
+			// до /retry сообщение больше не меняется
+			delete(sent, job.ID)
+		default:
+			sent[job.ID] = text
+		}
+	}
+
+	realtime := pb.Realtime(jobCollections...)
+	realtime.OnConnect = func() {
+		log.Printf("Realtime: подписка на %s", strings.Join(jobCollections, ", "))
+		for _, collection := range jobCollections {
+			jobs, err := changedJobs(collection, since)
+			if err != nil {
+				log.Printf("Realtime: %v", err)
+				continue
+			}
+			for i := range jobs {
+				update(collection, &jobs[i])
+			}
+		}
+	}
+	realtime.OnDisconnect = func(err error, retryIn time.Duration) {
+		log.Printf("Realtime: подписка прервана, переподключение через %s: %v", retryIn, err)
+	}
+	realtime.OnEvent = func(topic string, event pbclient.RecordEvent) {
+		if event.Action != "update" {
+			return
+		}
+
+		var job pbclient.Job
+		if err := event.Decode(&job); err != nil {
+			log.Printf("Realtime: ошибка разбора задачи %s: %v", topic, err)
+			return
+		}
+		update(topic, &job)
+	}
+
+	realtime.Run(ctx)
+}
+
+// Задачи с сообщением о статусе, измененные после since, в порядке изменения
+func changedJobs(collection string, since pbclient.DateTime) ([]pbclient.Job, error) {
+	filter, err := pbclient.Filter("status_message_id!=0 && updated>{:since}", pbclient.Params{"since": since})
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := pbclient.ListAll[pbclient.Job](pb, collection, pbclient.ListQuery{
+		Filter: filter,
+		Sort:   "updated",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при запросе измененных задач %s: %w", collection, err)
+	}
+	return jobs, nil
+}
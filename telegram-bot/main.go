@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
+	"time"
+
+	"pbclient"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
@@ -25,83 +31,367 @@ func handleStatusCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
 			"💰 Монеты: %d\n"+
 			"🌀 Кружков создано: %d\n"+
 			"💼 Замены лиц: %d\n\n",
-		userData["username"],
+		userData.Username,
 		tgUserID,
-		int(userData["coins"].(float64)),
-		int(userData["circle_count"].(float64)),
-		int(userData["face_replace_count"].(float64)),
+		userData.Coins,
+		userData.CircleCount,
+		userData.FaceReplaceCount,
 	)
 
-	activeJobs, err := getActiveJobs(userData["id"].(string), "face_jobs")
-	if err != nil {
-		return fmt.Errorf("ошибка при получении активных задач: %v", err)
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	sections := []struct {
+		collection, title, empty string
+	}{
+		{pbclient.FaceJobsCollection, "📋 Активные задачи замены лиц:\n", "У вас нет активных задач замены лиц.\n"},
+		{pbclient.CircleJobsCollection, "📋 Активные задачи создания кружков:\n", ""},
 	}
-	if len(activeJobs) > 0 {
-		response += "📋 Активные задачи замены лиц:\n"
+	hidden := 0
+	for _, section := range sections {
+		activeJobs, total, err := getActiveJobs(userData.ID, section.collection, statusJobsLimit)
+		if err != nil {
+			return fmt.Errorf("ошибка при получении активных задач: %v", err)
+		}
+		hidden += total - len(activeJobs)
+		if len(activeJobs) == 0 {
+			response += section.empty
+			continue
+		}
+
+		average := averageDuration(section.collection)
+		response += section.title
 		for _, job := range activeJobs {
+			buttons = appendJobButton(buttons, section.collection, job)
 			response += fmt.Sprintf(
 				"🔹 Задача ID: %s\n"+
 					"   Статус: %s\n"+
+					"%s"+
 					"   Время: %s\n"+
 					"   Обновлена: %s\n\n",
-				job["id"],
-				job["status"],
-				job["created"],
-				job["updated"],
+				job.ID,
+				statusLabel(job.Status),
+				queueLine(section.collection, &job, average),
+				job.Created,
+				job.Updated,
 			)
 		}
-	} else {
-		response += "У вас нет активных задач замены лиц.\n"
+	}
+	// сообщение и клавиатура не растут с числом задач: лимиты Telegram 4096 символов и 100 кнопок
+	if hidden > 0 {
+		response += fmt.Sprintf("…и еще %d. Все задачи: /history\n", hidden)
 	}
 
-	activeJobs, err = getActiveJobs(userData["id"].(string), "circle_jobs")
+	msg := tgbotapi.NewMessage(tgChatID, response)
+	if len(buttons) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	}
+	_, err = bot.Send(msg)
 	if err != nil {
-		return fmt.Errorf("ошибка при получении активных задач: %v", err)
+		return fmt.Errorf("ошибка отправки статуса: %v", err)
 	}
-	if len(activeJobs) > 0 {
-		response += "📋 Активные задачи создания кружков:\n"
-		for _, job := range activeJobs {
-			response += fmt.Sprintf(
-				"🔹 Задача ID: %s\n"+
-					"   Статус: %s\n"+
-					"   Время: %s\n"+
-					"   Обновлена: %s\n\n",
-				job["id"],
-				job["status"],
-				job["created"],
-				job["updated"],
-			)
+	return nil
+}
+
+// Средняя длительность задач коллекции для оценки ожидания в /status; 0, если оценить нельзя
+func averageDuration(collection string) time.Duration {
+	average, err := averageJobDuration(collection)
+	if err != nil {
+		log.Printf("Не удалось оценить время обработки задач %s: %v", collection, err)
+	}
+	return average
+}
+
+// Строка /status с местом задачи в очереди; пустая, если задача не в очереди
+func queueLine(collection string, job *pbclient.Job, average time.Duration) string {
+	if job.Status != "queued" {
+		return ""
+	}
+	position, err := queuePosition(collection, job)
+	if err != nil {
+		log.Printf("Не удалось определить место задачи %s в очереди: %v", job.ID, err)
+		return ""
+	}
+	return "   " + queueText(position, average*time.Duration(position)) + "\n"
+}
+
+// Кнопка отмены для задачи в работе или повтора для неудавшейся
+func appendJobButton(buttons [][]tgbotapi.InlineKeyboardButton, collection string, job pbclient.Job) [][]tgbotapi.InlineKeyboardButton {
+	switch job.Status {
+	case "queued", "processing":
+		return append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отменить "+job.ID, jobCallbackData("cancel", collection, job.ID)),
+		))
+	case "failed", "dead":
+		return append(buttons, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔁 Повторить "+job.ID, jobCallbackData("retry", collection, job.ID)),
+		))
+	}
+	return buttons
+}
+
+// данные inline-кнопки действия над задачей: "<action>:<collection>:<id>"
+func jobCallbackData(action, collection, jobID string) string {
+	return fmt.Sprintf("%s:%s:%s", action, collection, jobID)
+}
+
+// для обработки команды /cancel <id>
+func handleCancelCommand(r *request) {
+	if len(r.args) == 0 {
+		r.reply("Укажите ID задачи: /cancel <id>. ID активных задач есть в /status.")
+		return
+	}
+	jobID := r.args[0]
+
+	err := cancelJob(r.userID, "", jobID)
+	r.reply(cancelResultText(jobID, err))
+}
+
+// для обработки команды /retry <id>
+func handleRetryCommand(r *request) {
+	if len(r.args) == 0 {
+		r.reply("Укажите ID задачи: /retry <id>. ID задач с ошибкой есть в /status.")
+		return
+	}
+	jobID := r.args[0]
+
+	position, err := retryJob(r.userID, "", jobID)
+	r.reply(retryResultText(jobID, position, err))
+}
+
+// Нажатие inline-кнопки под сообщением бота
+func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+	pbUserID, err := getOrCreateUser(int(query.From.ID), query.From.UserName)
+	if err != nil {
+		log.Printf("Ошибка при получении/создании пользователя: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Произошла ошибка, попробуйте позже."))
+		return
+	}
+
+	// страница истории: "history:<page>"
+	if page, ok := strings.CutPrefix(query.Data, "history:"); ok {
+		handleHistoryPage(bot, query, pbUserID, page)
+		return
+	}
+
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		bot.Request(tgbotapi.NewCallback(query.ID, "Неизвестная команда."))
+		return
+	}
+	action, collection, jobID := parts[0], parts[1], parts[2]
+
+	var text string
+	switch action {
+	case "cancel":
+		err = cancelJob(pbUserID, collection, jobID)
+		text = cancelResultText(jobID, err)
+	case "retry":
+		var position int
+		position, err = retryJob(pbUserID, collection, jobID)
+		text = retryResultText(jobID, position, err)
+	case "download":
+		chatID := query.From.ID
+		if query.Message != nil {
+			chatID = query.Message.Chat.ID
+		}
+		// отправка файла может занять дольше, чем Telegram ждет ответа на кнопку
+		bot.Request(tgbotapi.NewCallback(query.ID, "Отправляю результат..."))
+		err = sendJobResult(bot, chatID, pbUserID, collection, jobID)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, downloadResultText(jobID, err)))
 		}
+		return
+	default:
+		bot.Request(tgbotapi.NewCallback(query.ID, "Неизвестная команда."))
+		return
 	}
 
-	msg := tgbotapi.NewMessage(tgChatID, response)
-	bot.Send(msg)
-	return nil
+	bot.Request(tgbotapi.NewCallback(query.ID, text))
+	if query.Message != nil {
+		bot.Send(tgbotapi.NewMessage(query.Message.Chat.ID, text))
+	}
+}
+
+// Ответ пользователю на отмену задачи
+func cancelResultText(jobID string, err error) string {
+	switch {
+	case err == nil:
+		return fmt.Sprintf("Задача %s отменена.", jobID)
+	case pbclient.IsNotFound(err):
+		return fmt.Sprintf("Задача %s не найдена.", jobID)
+	case pbclient.StatusCode(err) == http.StatusConflict:
+		return fmt.Sprintf("Задачу %s уже нельзя отменить: она завершена или отправляется.", jobID)
+	}
+	log.Printf("Не удалось отменить задачу: %v", err)
+	return "Произошла ошибка при отмене задачи. Если ситуация повторяется, обратитесь в поддержку."
 }
 
-type UserSession struct {
-	FaceFileID string // временное хранение ID файла фотографии
+// Ответ пользователю на повтор задачи
+func retryResultText(jobID string, position int, err error) string {
+	switch {
+	case err == nil && position > 0:
+		return fmt.Sprintf("Задача %s снова в очереди. Место в очереди: %d.", jobID, position)
+	case err == nil:
+		return fmt.Sprintf("Задача %s снова в очереди.", jobID)
+	case pbclient.IsNotFound(err):
+		return fmt.Sprintf("Задача %s не найдена.", jobID)
+	case pbclient.StatusCode(err) == http.StatusConflict:
+		return fmt.Sprintf("Задачу %s нельзя повторить: она еще выполняется или уже завершена.", jobID)
+	case pbclient.StatusCode(err) == http.StatusPaymentRequired:
+		return fmt.Sprintf("Недостаточно монет, чтобы повторить задачу %s.", jobID)
+	}
+	log.Printf("Не удалось повторить задачу: %v", err)
+	return "Произошла ошибка при повторе задачи. Если ситуация повторяется, обратитесь в поддержку."
 }
 
-// Функция для получения или создания сессии пользователя
-func getUserSession(userID int) *UserSession {
-	if session, ok := userSessions[userID]; ok {
-		return session
+// Человекочитаемый статус задачи
+func statusLabel(status string) string {
+	switch status {
+	case "pending":
+		return "Ожидает оплаты"
+	case "queued":
+		return "В очереди"
+	case "processing":
+		return "Обрабатывается"
+	case "sending":
+		return "Отправляется"
+	case "completed":
+		return "Готово"
+	case "failed":
+		return "Ошибка"
+	case "dead":
+		return "Не выполнена: исчерпаны повторные попытки"
+	case "cancelled":
+		return "Отменена"
+	default:
+		return status
 	}
-	// Создаем новую сессию, если её еще нет
-	userSessions[userID] = &UserSession{}
-	return userSessions[userID]
 }
 
-// Хранилище сессий пользователей
-var userSessions = make(map[int]*UserSession)
+// Ответ пользователю, если задачу не удалось создать
+func jobErrorText(err error) string {
+	var coinsErr *insufficientCoinsError
+	if errors.As(err, &coinsErr) {
+		return fmt.Sprintf("💰 Недостаточно монет: задача стоит %d, на балансе %d.", coinsErr.Price, coinsErr.Balance)
+	}
+	if pbclient.IsValidation(err) {
+		// pocketbase не принял файл или поля задачи, повтор не поможет
+		return "Файл не принят: проверьте формат и размер видео."
+	}
+	return "Произошла ошибка при создании задания. Если ситуация повторяется, обратитесь в поддержку."
+}
+
+// Ответ о созданной задаче: правка сообщения о статусе, а если его нет - новое сообщение
+func sendJobStatus(bot *tgbotapi.BotAPI, chatID int64, statusMessage tgbotapi.Message, collection string, job *pbclient.Job) {
+	text := jobStatusText(collection, job)
+	if statusMessage.MessageID != 0 {
+		err := editStatusMessage(bot, chatID, statusMessage.MessageID, text)
+		if err == nil {
+			return
+		}
+		log.Printf("Ошибка обновления сообщения о статусе задачи %s: %v", job.ID, err)
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, text))
+}
+
+// Отмена начатого действия: сессия сбрасывается, клавиатура с "Отменить" убирается
+func cancelFlow(bot *tgbotapi.BotAPI, chatID int64, session *Session) {
+	err := session.Reset()
+	if err != nil {
+		log.Printf("Не удалось сбросить сессию: %v", err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "Операция отменена.")
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	bot.Send(msg)
+}
+
+// Сообщения без команды: фото лица, видео для задачи, кнопка "Отменить"
+func handleMedia(r *request) error {
+	// Обработка получения фотографии
+	if r.message.Photo != nil {
+		fileID := r.message.Photo[len(r.message.Photo)-1].FileID
+		// сохраняем ID фото до видео для замены лица
+		err := r.session.Transition(stateAwaitingFaceVideo, map[string]string{"face_file_id": fileID})
+		if err != nil {
+			log.Printf("Не удалось сохранить сессию: %v", err)
+			msg := tgbotapi.NewMessage(r.message.Chat.ID, "Произошла ошибка, попробуйте отправить фото еще раз.")
+			r.bot.Send(msg)
+			return nil
+		}
+
+		msg := tgbotapi.NewMessage(r.message.Chat.ID, "Получена фотография. Пожалуйста, отправьте видео для замены лица.")
+		cancelMarkup := tgbotapi.NewReplyKeyboard(
+			tgbotapi.NewKeyboardButtonRow(
+				tgbotapi.NewKeyboardButton("Отменить"),
+			),
+		)
+		msg.ReplyMarkup = cancelMarkup
+		r.bot.Send(msg)
+		return nil
+	}
+
+	// Обработка получения видео: видео, кружок, GIF или видео файлом
+	video, err := messageVideo(r.message)
+	if errors.Is(err, errNotVideo) {
+		log.Printf("Отклонен документ от %d: %v", r.message.From.ID, err)
+		r.reply("Этот файл не похож на видео. Пришлите видео, кружок, GIF или видеофайл (mp4, mov, webm).")
+		return nil
+	}
+	if video != nil {
+		// это сообщение станет сообщением о статусе задачи
+		msg := tgbotapi.NewMessage(r.message.Chat.ID, "Ловлю!")
+		statusMessage, err := r.bot.Send(msg)
+		if err != nil {
+			log.Printf("Не удалось отправить сообщение о статусе: %v", err)
+		}
+
+		// Проверяем, ждет ли сессия видео для замены лица
+		if r.session.State == stateAwaitingFaceVideo {
+			job, err := createFaceJob(r.bot, r.userID, video.FileID, r.session.Get("face_file_id"), statusMessage)
+			if err != nil {
+				log.Printf("Не удалось создать задание на замену лица: %v", err)
+				msg := tgbotapi.NewMessage(r.message.Chat.ID, jobErrorText(err))
+				r.bot.Send(msg)
+				return nil
+			}
+
+			sendJobStatus(r.bot, r.message.Chat.ID, statusMessage, pbclient.FaceJobsCollection, job)
+
+			// Сбрасываем данные сессии
+			if err := r.session.Reset(); err != nil {
+				log.Printf("Не удалось сбросить сессию: %v", err)
+			}
+			return nil
+		} else {
+			job, err := createCircleJob(r.bot, r.userID, video, statusMessage)
+			if err != nil {
+				log.Printf("Не удалось создать задание на создание кружочка: %v", err)
+				msg := tgbotapi.NewMessage(r.message.Chat.ID, jobErrorText(err))
+				r.bot.Send(msg)
+				return nil
+			}
+
+			sendJobStatus(r.bot, r.message.Chat.ID, statusMessage, pbclient.CircleJobsCollection, job)
+
+			// Сбрасываем временные данные
+			return nil
+		}
+	}
+
+	// Обработка команды отмены
+	if r.message.Text == "Отменить" {
+		cancelFlow(r.bot, r.message.Chat.ID, r.session)
+		return nil
+	}
+	return nil
+}
 
 func main() {
 	// load variables
 	BOT_TOKEN, BOT_DEBUG, BOT_ENDPOINT := LoadEnvironment()
 
 	// auth pocketbase
-	err := authenticatePocketBase()
+	err := pb.Authenticate()
 	if err != nil {
 		panic(err)
 	}
@@ -118,119 +408,35 @@ func main() {
 		log.Print("bot in DEBUG mode")
 	}
 
-	// updates on telegram API
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-
-	// Основной обработчик
-	updates := bot.GetUpdatesChan(u)
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
-
-		userID := update.Message.From.ID
-		userName := update.Message.From.UserName
-
-		pbUserID, err := getOrCreateUser(int(userID), userName)
-		if err != nil {
-			log.Printf("Ошибка при получении/создании пользователя: %v", err)
-			continue
-		}
-
-		// Получаем сессию для текущего пользователя
-		session := getUserSession(int(userID))
-
-		// Приветственное сообщение
-		if update.Message.Text != "" && strings.Contains(strings.ToLower(update.Message.Text), "start") {
-			greeting := fmt.Sprintf("Привет, %s! Добро пожаловать! Справка: /help", userName)
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, greeting)
-			bot.Send(msg)
-			continue
-		}
+	// сообщения о статусе задач обновляются по мере обработки
+	if watchJobStatus {
+		go watchJobs(context.Background(), bot)
+	} else {
+		log.Print("Сообщения о статусе задач обновляет другой экземпляр бота (WATCH_JOB_STATUS=false)")
+	}
 
-		// help
-		if update.Message.Text != "" && strings.Contains(strings.ToLower(update.Message.Text), "help") {
-			helpMessage := "Напиши мне фото для создания задачи по замене лица (временно недоступно). Пришли видео для создания кружочка. Канал с новостями https://t.me/+HGQVwMhFzIExZDNi"
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, helpMessage)
-			bot.Send(msg)
-			continue
-		}
+	rt := newBotRouter()
+	if err := rt.RegisterCommands(bot); err != nil {
+		log.Printf("Не удалось зарегистрировать команды: %v", err)
+	}
 
-		// status
-		if update.Message.Text != "" && strings.Contains(strings.ToLower(update.Message.Text), "status") {
-			err = handleStatusCommand(bot, update)
-			if err != nil {
-				log.Printf("Не удалось получить статус пользователя: %v", err)
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Произошла ошибка при получении статуса: %v", err))
-				bot.Send(msg)
-				continue
-			}
-			continue
+	// Основной обработчик: обновления разных пользователей обрабатываются параллельно
+	d := newDispatcher(botConcurrency, func(update tgbotapi.Update) {
+		if update.CallbackQuery != nil {
+			handleCallbackQuery(bot, update.CallbackQuery)
+			return
 		}
-
-		// Обработка получения фотографии
-		if update.Message.Photo != nil {
-			fileID := update.Message.Photo[len(update.Message.Photo)-1].FileID
-			session.FaceFileID = fileID // сохраняем ID фото для текущего пользователя
-
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Получена фотография. Пожалуйста, отправьте видео для замены лица.")
-			cancelMarkup := tgbotapi.NewReplyKeyboard(
-				tgbotapi.NewKeyboardButtonRow(
-					tgbotapi.NewKeyboardButton("Отменить"),
-				),
-			)
-			msg.ReplyMarkup = cancelMarkup
-			bot.Send(msg)
-			continue
+		if update.Message == nil {
+			return
 		}
 
-		// Обработка получения видео
-		if update.Message.Video != nil {
-			videoFileID := update.Message.Video.FileID
-
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Ловлю!")
-			bot.Send(msg)
-
-			// Проверяем, есть ли фото в сессии пользователя
-			if session.FaceFileID != "" {
-				jobID, err := createFaceJob(bot, pbUserID, videoFileID, session.FaceFileID)
-				if err != nil {
-					log.Printf("Не удалось создать задание на замену лица: %v", err)
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Произошла ошибка при создании задания. Если ситуация повторяется, обратитесь в поддержку.")
-					bot.Send(msg)
-					continue
-				}
-
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ваше видео поставлено в очередь для обработки. Статус: В очереди. ID: %s.", jobID))
-				bot.Send(msg)
-
-				// Сбрасываем данные сессии
-				session.FaceFileID = ""
-				continue
-			} else {
-				jobID, err := createCircleJob(bot, pbUserID, videoFileID)
-				if err != nil {
-					log.Printf("Не удалось создать задание на создание кружочка: %v", err)
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Произошла ошибка при создании задания. Если ситуация повторяется, обратитесь в поддержку.")
-					bot.Send(msg)
-					continue
-				}
-
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ваше видео поставлено в очередь для обработки. Статус: В очереди. ID: %s.", jobID))
-				bot.Send(msg)
-
-				// Сбрасываем временные данные
-				continue
-			}
-		}
+		rt.Dispatch(bot, update)
+	})
 
-		// Обработка команды отмены
-		if update.Message.Text == "Отменить" {
-			session.FaceFileID = "" // Сбрасываем временные данные в сессии
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Операция отменена.")
-			bot.Send(msg)
-			continue
-		}
+	// updates on telegram API: webhook, если задан WEBHOOK_URL, иначе long polling
+	if webhookURL != "" {
+		log.Fatal(runWebhook(bot, d))
 	}
+	runPolling(bot, d)
+	d.Wait()
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// виды сообщений, из которых принимается видео; сохраняются в input_kind задачи кружка
+const (
+	inputVideo     = "video"
+	inputVideoNote = "video_note"
+	inputAnimation = "animation"
+	inputDocument  = "document"
+)
+
+// файл документа не является видео
+var errNotVideo = errors.New("файл не является видео")
+
+// videoInput - видео из сообщения: файл Telegram, вид сообщения и MIME-тип
+type videoInput struct {
+	FileID   string
+	Kind     string
+	MimeType string
+}
+
+// Видео из сообщения: видео, кружок, GIF (Animation) или видео, отправленное файлом.
+// nil без ошибки - в сообщении нет видео; документ другого типа - errNotVideo.
+func messageVideo(message *tgbotapi.Message) (*videoInput, error) {
+	switch {
+	case message.Video != nil:
+		return &videoInput{
+			FileID:   message.Video.FileID,
+			Kind:     inputVideo,
+			MimeType: mimeOrDefault(message.Video.MimeType, "video/mp4"),
+		}, nil
+	case message.VideoNote != nil:
+		// у кружков Telegram не передает mime_type, это всегда mp4
+		return &videoInput{FileID: message.VideoNote.FileID, Kind: inputVideoNote, MimeType: "video/mp4"}, nil
+	case message.Animation != nil:
+		// вместе с Animation Telegram заполняет и Document, поэтому Animation проверяется раньше
+		return &videoInput{
+			FileID:   message.Animation.FileID,
+			Kind:     inputAnimation,
+			MimeType: mimeOrDefault(message.Animation.MimeType, "video/mp4"),
+		}, nil
+	case message.Document != nil:
+		mimeType := message.Document.MimeType
+		if mimeType == "" {
+			mimeType = mime.TypeByExtension(strings.ToLower(filepath.Ext(message.Document.FileName)))
+		}
+		if !isVideoMime(mimeType) {
+			return nil, fmt.Errorf("%s (%q): %w", message.Document.FileName, mimeType, errNotVideo)
+		}
+		return &videoInput{FileID: message.Document.FileID, Kind: inputDocument, MimeType: mimeType}, nil
+	}
+	return nil, nil
+}
+
+// MIME-типы, которые обрабатывает ffmpeg в job-manager: любое видео и GIF
+func isVideoMime(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "video/") || mediaType == "image/gif"
+}
+
+func mimeOrDefault(mimeType, fallback string) string {
+	if mimeType == "" {
+		return fallback
+	}
+	return mimeType
+}
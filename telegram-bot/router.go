@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// request - входящее сообщение и данные, которые заполняют middleware
+type request struct {
+	bot     *tgbotapi.BotAPI
+	update  tgbotapi.Update
+	message *tgbotapi.Message
+	command string   // команда без "/" и "@bot"; пусто для обычного сообщения
+	args    []string // аргументы команды через пробел
+
+	userID  string // ID пользователя в pocketbase (withUser)
+	session *Session
+}
+
+// Ответ текстом в чат сообщения
+func (r *request) reply(text string) {
+	r.bot.Send(tgbotapi.NewMessage(r.message.Chat.ID, text))
+}
+
+// handler - обработчик команды или обычного сообщения
+type handler func(r *request) error
+
+// middleware - обертка обработчика: может дополнить request или прервать обработку
+type middleware func(next handler) handler
+
+type command struct {
+	name        string
+	description string
+	handler     handler
+}
+
+// router - выбор обработчика по Message.Command(): команды из списка, остальные сообщения - в fallback
+type router struct {
+	commands   map[string]command
+	order      []string // порядок команд для setMyCommands
+	middleware []middleware
+	fallback   handler
+}
+
+func newRouter() *router {
+	return &router{commands: make(map[string]command)}
+}
+
+// Команда /name; description показывается в меню команд Telegram
+func (rt *router) Handle(name, description string, h handler) {
+	if _, ok := rt.commands[name]; ok {
+		log.Fatalf("command %s registered twice", name)
+	}
+	rt.commands[name] = command{name: name, description: description, handler: h}
+	rt.order = append(rt.order, name)
+}
+
+// Обработчик сообщений без команды (фото, видео, кнопки клавиатуры)
+func (rt *router) Fallback(h handler) {
+	rt.fallback = h
+}
+
+// Middleware выполняются в порядке добавления, до выбора обработчика
+func (rt *router) Use(m middleware) {
+	rt.middleware = append(rt.middleware, m)
+}
+
+// Обработка сообщения из update
+func (rt *router) Dispatch(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	message := update.Message
+	req := &request{bot: bot, update: update, message: message}
+
+	if message.IsCommand() {
+		name, target, _ := strings.Cut(message.CommandWithAt(), "@")
+		// в группах "/cmd@other_bot" адресована другому боту
+		if target != "" && !strings.EqualFold(target, bot.Self.UserName) {
+			return
+		}
+		req.command = strings.ToLower(name)
+		req.args = strings.Fields(message.CommandArguments())
+	}
+
+	h := rt.route
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+
+	err := h(req)
+	if err != nil {
+		log.Printf("Ошибка обработки сообщения от %d (%q): %v", message.From.ID, req.command, err)
+		req.reply("Произошла ошибка. Если ситуация повторяется, обратитесь в поддержку.")
+	}
+}
+
+func (rt *router) route(r *request) error {
+	if r.command == "" {
+		if rt.fallback == nil {
+			return nil
+		}
+		return rt.fallback(r)
+	}
+
+	cmd, ok := rt.commands[r.command]
+	if !ok {
+		r.reply(fmt.Sprintf("Неизвестная команда /%s. Справка: /help", r.command))
+		return nil
+	}
+	return cmd.handler(r)
+}
+
+// Регистрация списка команд в меню Telegram (setMyCommands)
+func (rt *router) RegisterCommands(bot *tgbotapi.BotAPI) error {
+	commands := make([]tgbotapi.BotCommand, 0, len(rt.order))
+	for _, name := range rt.order {
+		commands = append(commands, tgbotapi.BotCommand{
+			Command:     name,
+			Description: rt.commands[name].description,
+		})
+	}
+
+	_, err := bot.Request(tgbotapi.NewSetMyCommands(commands...))
+	if err != nil {
+		return fmt.Errorf("ошибка регистрации команд: %v", err)
+	}
+	return nil
+}
+
+// Журнал входящих команд с временем обработки
+func withLogging(next handler) handler {
+	return func(r *request) error {
+		if r.command == "" {
+			return next(r)
+		}
+
+		started := time.Now()
+		err := next(r)
+		log.Printf("Команда /%s от %d обработана за %s", r.command, r.message.From.ID, time.Since(started).Round(time.Millisecond))
+		return err
+	}
+}
+
+// Пользователь pocketbase и сессия для отправителя сообщения
+func withUser(next handler) handler {
+	return func(r *request) error {
+		from := r.message.From
+		userID, err := getOrCreateUser(int(from.ID), from.UserName)
+		if err != nil {
+			return fmt.Errorf("ошибка при получении/создании пользователя: %w", err)
+		}
+		r.userID = userID
+
+		r.session, err = loadSession(int(from.ID))
+		if err != nil {
+			return fmt.Errorf("ошибка при получении сессии: %w", err)
+		}
+		return next(r)
+	}
+}
+
+// rateLimiter - ограничение числа сообщений от одного пользователя за окно времени
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[int64]*rateWindow
+}
+
+type rateWindow struct {
+	started time.Time
+	count   int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: make(map[int64]*rateWindow)}
+}
+
+// Сообщение укладывается в лимит; warn - это первое сообщение сверх лимита в окне
+func (l *rateLimiter) allow(userID int64) (ok, warn bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, found := l.windows[userID]
+	if !found || now.Sub(w.started) >= l.window {
+		// заодно удаляем окна, которые давно закончились
+		for id, old := range l.windows {
+			if now.Sub(old.started) >= l.window {
+				delete(l.windows, id)
+			}
+		}
+		w = &rateWindow{started: now}
+		l.windows[userID] = w
+	}
+
+	w.count++
+	return w.count <= l.limit, w.count == l.limit+1
+}
+
+// Сообщения сверх лимита не обрабатываются, о превышении пользователь узнает один раз за окно
+func (l *rateLimiter) middleware(next handler) handler {
+	return func(r *request) error {
+		ok, warn := l.allow(r.message.From.ID)
+		if ok {
+			return next(r)
+		}
+		if warn {
+			r.reply("Слишком много сообщений, подождите немного.")
+		}
+		return nil
+	}
+}
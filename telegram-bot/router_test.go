@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// botServer - Bot API для тестов: getMe отвечает ботом @thisbot, sendMessage запоминает тексты
+type botServer struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (s *botServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getMe"):
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Test","username":"thisbot"}}`)
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		s.mu.Lock()
+		s.sent = append(s.sent, r.FormValue("text"))
+		s.mu.Unlock()
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1,"type":"private"},"date":0}}`)
+	default:
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	}
+}
+
+func (s *botServer) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+func newTestBot(t *testing.T) (*tgbotapi.BotAPI, *botServer) {
+	t.Helper()
+	server := &botServer{}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithClient("token", ts.URL+"/bot%s/%s", ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot, server
+}
+
+// Обновление с текстом от пользователя userID; команда в начале текста размечается как bot_command
+func textUpdate(userID int64, text string) tgbotapi.Update {
+	message := &tgbotapi.Message{
+		Text: text,
+		From: &tgbotapi.User{ID: userID},
+		Chat: tgbotapi.Chat{ID: userID, Type: "private"},
+	}
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	return tgbotapi.Update{Message: message}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    string // вызванный обработчик: status, fallback или пусто
+		args    string
+		unknown bool // ответ о неизвестной команде
+	}{
+		{"/status@thisbot a b", "status", "[a b]", false},
+		{"/status", "status", "[]", false},
+		{"/Status@ThisBot", "status", "[]", false},
+		{"/status@otherbot", "", "", false},
+		{"/status@otherbot a", "", "", false},
+		{"status", "fallback", "", false},
+		{"/statusx", "", "", true},
+		{"/statusx@thisbot", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			bot, server := newTestBot(t)
+
+			var called, args string
+			rt := newRouter()
+			rt.Handle("status", "Статус", func(r *request) error {
+				called, args = "status", fmt.Sprint(r.args)
+				return nil
+			})
+			rt.Fallback(func(r *request) error {
+				called = "fallback"
+				return nil
+			})
+
+			rt.Dispatch(bot, textUpdate(1, tt.text))
+
+			if called != tt.want || (tt.want == "status" && args != tt.args) {
+				t.Errorf("вызван %q с аргументами %s, ожидался %q с %s", called, args, tt.want, tt.args)
+			}
+			sent := server.messages()
+			if tt.unknown != (len(sent) == 1 && strings.HasPrefix(sent[0], "Неизвестная команда")) {
+				t.Errorf("ответы %q", sent)
+			}
+			if !tt.unknown && len(sent) != 0 {
+				t.Errorf("лишние ответы %q", sent)
+			}
+		})
+	}
+}
+
+// Middleware выполняются в порядке добавления и оборачивают обработчик; прерванная цепочка не доходит до него
+func TestRouterMiddlewareOrder(t *testing.T) {
+	bot, _ := newTestBot(t)
+
+	var trace []string
+	named := func(name string) middleware {
+		return func(next handler) handler {
+			return func(r *request) error {
+				trace = append(trace, name+">")
+				if name == "stop" && r.command == "blocked" {
+					return nil
+				}
+				err := next(r)
+				trace = append(trace, "<"+name)
+				return err
+			}
+		}
+	}
+
+	rt := newRouter()
+	rt.Use(named("first"))
+	rt.Use(named("stop"))
+	rt.Use(named("last"))
+	rt.Handle("ping", "", func(r *request) error {
+		trace = append(trace, "ping")
+		return nil
+	})
+	rt.Handle("blocked", "", func(r *request) error {
+		trace = append(trace, "blocked")
+		return nil
+	})
+
+	rt.Dispatch(bot, textUpdate(1, "/ping"))
+	if got := strings.Join(trace, " "); got != "first> stop> last> ping <last <stop <first" {
+		t.Errorf("порядок: %s", got)
+	}
+
+	trace = nil
+	rt.Dispatch(bot, textUpdate(1, "/blocked"))
+	if got := strings.Join(trace, " "); got != "first> stop> <first" {
+		t.Errorf("прерванная цепочка: %s", got)
+	}
+}
+
+// 21-е сообщение за минуту не обрабатывается, предупреждение отправляется один раз
+func TestRateLimiterMiddleware(t *testing.T) {
+	bot, server := newTestBot(t)
+
+	handled := make(map[int64]int)
+	rt := newRouter()
+	rt.Use(newRateLimiter(rateLimitMessages, rateLimitWindow).middleware)
+	rt.Handle("ping", "", func(r *request) error {
+		handled[r.message.From.ID]++
+		return nil
+	})
+
+	for i := 0; i < rateLimitMessages+5; i++ {
+		rt.Dispatch(bot, textUpdate(1, "/ping"))
+	}
+	rt.Dispatch(bot, textUpdate(2, "/ping"))
+
+	if handled[1] != rateLimitMessages {
+		t.Errorf("обработано %d сообщений, ожидалось %d", handled[1], rateLimitMessages)
+	}
+	if handled[2] != 1 {
+		t.Error("лимит одного пользователя задел другого")
+	}
+	if sent := server.messages(); len(sent) != 1 || !strings.HasPrefix(sent[0], "Слишком много сообщений") {
+		t.Errorf("предупреждения %q, ожидалось одно", sent)
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	limiter := newRateLimiter(2, 50*time.Millisecond)
+
+	results := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		ok, warn := limiter.allow(1)
+		results = append(results, fmt.Sprint(ok, warn))
+	}
+	if got := strings.Join(results, " "); got != "true false true false false true false false" {
+		t.Errorf("allow: %s", got)
+	}
+
+	// новое окно: лимит и предупреждение снова доступны
+	time.Sleep(60 * time.Millisecond)
+	if ok, warn := limiter.allow(1); !ok || warn {
+		t.Error("в новом окне сообщение не пропущено")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"pbclient"
+)
+
+// состояния диалога с пользователем
+const (
+	// нет начатого действия
+	stateIdle = ""
+	// фото лица получено, ждем видео для замены лица; data: face_file_id
+	stateAwaitingFaceVideo = "awaiting_face_video"
+)
+
+// сколько сессия ждет следующего шага пользователя
+const sessionTTL = 30 * time.Minute
+
+// Session - состояние диалога пользователя, хранится в коллекции sessions,
+// поэтому переживает перезапуск бота и общее для нескольких его экземпляров
+type Session struct {
+	pbclient.Session
+}
+
+// Сессия пользователя по Telegram ID; без записи или с истекшим сроком - в stateIdle
+func loadSession(tgUserID int) (*Session, error) {
+	filter, err := pbclient.Filter("tgid={:tgid}", pbclient.Params{"tgid": tgUserID})
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := pbclient.First[pbclient.Session](pb, pbclient.SessionsCollection, filter)
+	if pbclient.IsNotFound(err) {
+		return &Session{pbclient.Session{TGID: tgUserID}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при запросе сессии: %w", err)
+	}
+
+	session := &Session{*record}
+	if session.ExpiresAt.Before(time.Now()) {
+		// запись удалит очистка в pocketbase, шаг диалога уже неактуален
+		session.State = stateIdle
+		session.Data = nil
+	}
+	return session, nil
+}
+
+// Переход в состояние state с данными шага; срок сессии продлевается на sessionTTL
+func (s *Session) Transition(state string, data map[string]string) error {
+	s.State = state
+	s.Data = data
+	s.ExpiresAt = pbclient.NewDateTime(time.Now().Add(sessionTTL))
+	return s.save()
+}
+
+// Возврат в stateIdle: действие завершено или отменено
+func (s *Session) Reset() error {
+	if s.ID == "" {
+		s.State = stateIdle
+		s.Data = nil
+		return nil
+	}
+
+	err := pb.Delete(pbclient.SessionsCollection, s.ID)
+	if err != nil && !pbclient.IsNotFound(err) {
+		return fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+	s.ID = ""
+	s.State = stateIdle
+	s.Data = nil
+	return nil
+}
+
+// Данные текущего шага
+func (s *Session) Get(key string) string {
+	return s.Data[key]
+}
+
+func (s *Session) save() error {
+	data := map[string]interface{}{
+		"tgid":       s.TGID,
+		"state":      s.State,
+		"data":       s.Data,
+		"expires_at": s.ExpiresAt,
+	}
+
+	if s.ID != "" {
+		err := pb.Update(pbclient.SessionsCollection, s.ID, data, nil)
+		if err == nil {
+			return nil
+		}
+		if !pbclient.IsNotFound(err) {
+			return fmt.Errorf("ошибка сохранения сессии: %w", err)
+		}
+		// сессию удалили (очистка или другой экземпляр бота), создаем заново
+	}
+
+	var created pbclient.Session
+	err := pb.Create(pbclient.SessionsCollection, data, &created)
+	if pbclient.IsValidation(err) {
+		// запись для tgid успела создать другая реплика бота: уникальный индекс по tgid
+		existing, loadErr := loadSession(s.TGID)
+		if loadErr != nil || existing.ID == "" {
+			return fmt.Errorf("ошибка создания сессии: %w", err)
+		}
+		s.ID = existing.ID
+		err = pb.Update(pbclient.SessionsCollection, s.ID, data, nil)
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения сессии: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка создания сессии: %w", err)
+	}
+	s.ID = created.ID
+	return nil
+}
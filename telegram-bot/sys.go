@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,43 +8,23 @@ import (
 	"net/http"
 	"os"
 
+	"pbclient"
+
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
-	"github.com/joho/godotenv"
 )
 
-// PocketBase global credentials
-var pocketBaseUrl string
-var email string
-var password string
-var authToken string
+// PocketBase client
+var pb *pbclient.Client
 var api_endpint string
 
-// just for sending search requests to pocketbase
-func sendAuthorizedRequest(method, url string, payload []byte) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(payload))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
+// сколько обновлений обрабатывается одновременно
+var botConcurrency int
 
-	if authToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
-	}
+// редактирование сообщений о статусе задач (watchJobs); из нескольких экземпляров бота включается у одного
+var watchJobStatus bool
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
-}
+// webhook вместо long polling: публичный адрес, адрес HTTP-сервера и secret_token
+var webhookURL, webhookListen, webhookSecret string
 
 type FileResponse struct {
 	Ok     bool                   `json:"ok"`
@@ -86,47 +65,21 @@ func getTelegramFile(bot *tgbotapi.BotAPI, fileID string) (string, error) {
 
 // loading env variables from .env or system environment
 func LoadEnvironment() (string, bool, string) {
-	if os.Getenv("DOCKER_BUILD") == `` {
-		err := godotenv.Load()
-		if err != nil {
-			log.Fatalf("Error loading .env file")
-		}
+	env := pbclient.LoadEnvironment()
+	pb = env.PocketBase()
+	api_endpint = env.BotEndpoint
+	botConcurrency = pbclient.IntEnv("BOT_CONCURRENCY", 8)
+	watchJobStatus = os.Getenv("WATCH_JOB_STATUS") != `false`
+
+	webhookURL = os.Getenv("WEBHOOK_URL")
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if webhookURL != `` && webhookSecret == `` {
+		log.Fatal("empty webhook secret loaded, check WEBHOOK_SECRET value")
 	}
-
-	// load telegram token
-	bot_token := os.Getenv("TELEGRAM_APITOKEN")
-	if bot_token == `` {
-		log.Fatal("empty telegram api token loaded, check TELEGRAM_APITOKEN value")
-	}
-
-	// telegram bot debug mode
-	var bot_debug bool
-	if os.Getenv("BOT_DEBUG") == `true` {
-		bot_debug = true
-	} else {
-		bot_debug = false
-	}
-
-	api_endpint = os.Getenv("TELEGRAM_API")
-	if api_endpint == `` {
-		api_endpint = "https://api.telegram.org"
-	}
-
-	// pocketbase
-	pocketBaseUrl = os.Getenv("POCKETBASE_URL")
-	if pocketBaseUrl == `` {
-		log.Fatal("empty pocketbase url loaded, check POCKETBASE_URL value")
-	}
-
-	email = os.Getenv("POCKETBASE_LOGIN")
-	if email == `` {
-		log.Fatal("empty pocketbase login loaded. env is not correct or configuration is insecure")
-	}
-
-	password = os.Getenv("POCKETBASE_PASSWORD")
-	if password == `` {
-		log.Fatal("empty pocketbase password loaded. env is not correct or configuration is insecure")
+	webhookListen = os.Getenv("WEBHOOK_LISTEN")
+	if webhookListen == `` {
+		webhookListen = ":8443"
 	}
 
-	return bot_token, bot_debug, api_endpint
+	return env.BotToken, env.BotDebug, api_endpint
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// заголовок, в котором Telegram передает secret_token из setWebhook
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// размер тела запроса с обновлением; обновления Telegram намного меньше
+const maxWebhookBody = 1 << 20
+
+// Telegram принимает max_connections от 1 до 100
+const maxWebhookConnections = 100
+
+// допустимый secret_token по документации Bot API
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookHandler - прием обновлений от Telegram: запрос проверяется по секрету
+// и передается в dispatcher, ответ отправляется сразу, не дожидаясь обработки
+type webhookHandler struct {
+	secret string
+	d      *dispatcher
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := r.Header.Get(webhookSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(h.secret)) != 1 {
+		log.Printf("Запрос webhook с неверным секретом от %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&update)
+	if err != nil {
+		log.Printf("Ошибка разбора обновления webhook: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	h.d.Dispatch(update)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Регистрация webhook в Telegram и HTTP-сервер для обновлений; возвращает только при ошибке
+func runWebhook(bot *tgbotapi.BotAPI, d *dispatcher) error {
+	if !webhookSecretPattern.MatchString(webhookSecret) {
+		return fmt.Errorf("WEBHOOK_SECRET должен содержать 1-256 символов A-Z, a-z, 0-9, _ и -")
+	}
+
+	link, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("некорректный WEBHOOK_URL: %v", err)
+	}
+	path := link.Path
+	if path == "" {
+		path = "/"
+	}
+
+	config := tgbotapi.WebhookConfig{
+		URL:            link,
+		MaxConnections: min(botConcurrency, maxWebhookConnections),
+		SecretToken:    webhookSecret,
+	}
+	_, err = bot.Request(config)
+	if err != nil {
+		return fmt.Errorf("ошибка регистрации webhook: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, &webhookHandler{secret: webhookSecret, d: d})
+	server := &http.Server{
+		Addr:              webhookListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("Webhook %s, сервер слушает %s%s", link.Redacted(), webhookListen, path)
+	return server.ListenAndServe()
+}
+
+// Обновления через getUpdates; webhook, оставшийся от прошлого запуска, удаляется - иначе getUpdates не работает
+func runPolling(bot *tgbotapi.BotAPI, d *dispatcher) {
+	_, err := bot.Request(tgbotapi.DeleteWebhookConfig{})
+	if err != nil {
+		log.Printf("Не удалось удалить webhook: %v", err)
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+
+	updates := bot.GetUpdatesChan(u)
+	for update := range updates {
+		d.Dispatch(update)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	const secret = "s3cret_token-1"
+	validUpdate := `{"update_id":7,"message":{"message_id":1,"from":{"id":42,"is_bot":false,"first_name":"U"},"chat":{"id":42,"type":"private"},"date":0,"text":"/start"}}`
+	// корректный JSON: отклонить его может только ограничение размера тела
+	oversized := `{"update_id":8,"message":{"text":"` + strings.Repeat("x", maxWebhookBody) + `"}}`
+
+	tests := []struct {
+		name     string
+		method   string
+		secret   *string // nil - заголовка нет
+		body     string
+		status   int
+		dispatch bool
+	}{
+		{"обновление", http.MethodPost, ptr(secret), validUpdate, http.StatusOK, true},
+		{"нет секрета", http.MethodPost, nil, validUpdate, http.StatusForbidden, false},
+		{"пустой секрет", http.MethodPost, ptr(""), validUpdate, http.StatusForbidden, false},
+		{"неверный секрет", http.MethodPost, ptr("wrong"), validUpdate, http.StatusForbidden, false},
+		{"префикс секрета", http.MethodPost, ptr(secret[:5]), validUpdate, http.StatusForbidden, false},
+		{"GET", http.MethodGet, ptr(secret), "", http.StatusMethodNotAllowed, false},
+		{"слишком большое тело", http.MethodPost, ptr(secret), oversized, http.StatusBadRequest, false},
+		{"не JSON", http.MethodPost, ptr(secret), "not json", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu         sync.Mutex
+				dispatched []tgbotapi.Update
+			)
+			d := newDispatcher(1, func(update tgbotapi.Update) {
+				mu.Lock()
+				dispatched = append(dispatched, update)
+				mu.Unlock()
+			})
+			handler := &webhookHandler{secret: secret, d: d}
+
+			req := httptest.NewRequest(tt.method, "/telegram", strings.NewReader(tt.body))
+			if tt.secret != nil {
+				req.Header.Set(webhookSecretHeader, *tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			d.Wait()
+
+			if rec.Code != tt.status {
+				t.Errorf("код ответа %d, ожидался %d", rec.Code, tt.status)
+			}
+			if tt.method == http.MethodGet && rec.Header().Get("Allow") != http.MethodPost {
+				t.Errorf("Allow: %q", rec.Header().Get("Allow"))
+			}
+			if tt.dispatch != (len(dispatched) == 1) || len(dispatched) > 1 {
+				t.Fatalf("передано обновлений: %d", len(dispatched))
+			}
+			if tt.dispatch {
+				update := dispatched[0]
+				if update.UpdateID != 7 || update.Message == nil || update.Message.Text != "/start" || update.Message.From.ID != 42 {
+					t.Errorf("обновление разобрано неверно: %+v", update)
+				}
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}